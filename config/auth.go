@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	defaultMaxFailedLogins = 10
+	defaultLockoutDuration = 15 * time.Minute
+	defaultLoginDelay      = time.Second
+)
+
+type AuthConfig struct {
+	// TokenSecret signs access and refresh tokens, it must be at least 32 characters
+	TokenSecret     Secret        `yaml:"token_secret"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// RequireVerifiedEmail makes login refuse accounts whose email address was never verified
+	RequireVerifiedEmail bool `yaml:"require_verified_email"`
+	// MaxFailedLogins consecutive failures lock an account for LockoutDuration, until an admin unlocks it
+	MaxFailedLogins int           `yaml:"max_failed_logins"`
+	LockoutDuration time.Duration `yaml:"lockout_duration"`
+	// LoginDelay is the first wait imposed after repeated failures, doubling with each further one
+	LoginDelay time.Duration `yaml:"login_delay"`
+}
+
+func defaultAuthConfig() AuthConfig {
+	return AuthConfig{
+		AccessTokenTTL:  defaultAccessTokenTTL,
+		RefreshTokenTTL: defaultRefreshTokenTTL,
+		MaxFailedLogins: defaultMaxFailedLogins,
+		LockoutDuration: defaultLockoutDuration,
+		LoginDelay:      defaultLoginDelay,
+	}
+}
+
+// reads the token settings from JWT_SECRET, ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL, and the login policy
+// from REQUIRE_VERIFIED_EMAIL, MAX_FAILED_LOGINS, LOCKOUT_DURATION and LOGIN_DELAY
+func (cfg *AuthConfig) applyEnv() error {
+	secretEnv("JWT_SECRET", &cfg.TokenSecret)
+	return errors.Join(
+		durationEnv("ACCESS_TOKEN_TTL", &cfg.AccessTokenTTL, "15m"),
+		durationEnv("REFRESH_TOKEN_TTL", &cfg.RefreshTokenTTL, "720h"),
+		boolEnv("REQUIRE_VERIFIED_EMAIL", &cfg.RequireVerifiedEmail),
+		intEnv("MAX_FAILED_LOGINS", &cfg.MaxFailedLogins, "10"),
+		durationEnv("LOCKOUT_DURATION", &cfg.LockoutDuration, "15m"),
+		durationEnv("LOGIN_DELAY", &cfg.LoginDelay, "1s"),
+	)
+}
+
+func (cfg AuthConfig) validate(v *validator) {
+	v.check(len(cfg.TokenSecret) >= 32, "auth.token_secret (JWT_SECRET) must be set to at least 32 characters")
+	v.positive(cfg.AccessTokenTTL, "auth.access_token_ttl (ACCESS_TOKEN_TTL)", "15m")
+	v.positive(cfg.RefreshTokenTTL, "auth.refresh_token_ttl (REFRESH_TOKEN_TTL)", "720h")
+	v.check(cfg.MaxFailedLogins > 0, "auth.max_failed_logins (MAX_FAILED_LOGINS) must be a positive number such as 10")
+	v.positive(cfg.LockoutDuration, "auth.lockout_duration (LOCKOUT_DURATION)", "15m")
+	v.check(cfg.LoginDelay >= 0, "auth.login_delay (LOGIN_DELAY) must not be negative")
+}
@@ -1,39 +1,301 @@
 package config
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
+	"gopkg.in/yaml.v3"
 )
 
-func ConnectDatabase() (*sql.DB, error) {
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+// Config is every setting the server reads at startup. Load starts from the defaults, applies the
+// YAML file named by CONFIG_FILE, then the environment, so a variable always wins over the file.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Password  PasswordConfig  `yaml:"password"`
+	Mail      MailConfig      `yaml:"mail"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// Secret is a setting that must never be printed, it formats and dumps as [redacted]
+type Secret string
+
+const redacted = "[redacted]"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+const (
+	defaultAddr              = ":8080"
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 15 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = time.Minute
+	defaultMaxHeaderBytes    = 64 << 10
+	defaultShutdownTimeout   = 30 * time.Second
+
+	defaultMaxOpenConns    = 25
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultConnectTimeout  = time.Minute
+	defaultRequestTimeout  = 10 * time.Second
+
+	maxConnectRetryDelay = 10 * time.Second
+)
+
+// the first wait between connection attempts, doubling up to maxConnectRetryDelay
+var connectRetryDelay = 500 * time.Millisecond
+
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+	// TLSCertFile and TLSKeyFile make the server speak HTTPS, both or neither must be set
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// ReadHeaderTimeout cuts off clients that trickle their headers in to hold connections open
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	// ShutdownTimeout is how long in-flight requests may take to finish once SIGTERM or SIGINT arrives
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	URL             Secret        `yaml:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// ConnectTimeout is how long startup keeps retrying an unreachable database before giving up
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// RequestTimeout bounds the database work of each request, past it the request is answered with a 503
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+}
+
+// returns the settings used when neither the file nor the environment sets them
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              defaultAddr,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+			ReadTimeout:       defaultReadTimeout,
+			WriteTimeout:      defaultWriteTimeout,
+			IdleTimeout:       defaultIdleTimeout,
+			MaxHeaderBytes:    defaultMaxHeaderBytes,
+			ShutdownTimeout:   defaultShutdownTimeout,
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:    defaultMaxOpenConns,
+			MaxIdleConns:    defaultMaxIdleConns,
+			ConnMaxLifetime: defaultConnMaxLifetime,
+			ConnMaxIdleTime: defaultConnMaxIdleTime,
+			ConnectTimeout:  defaultConnectTimeout,
+			RequestTimeout:  defaultRequestTimeout,
+		},
+		Auth:      defaultAuthConfig(),
+		Password:  defaultPasswordConfig(),
+		Mail:      defaultMailConfig(),
+		RateLimit: defaultRateLimitConfig(),
+		Retention: defaultRetentionConfig(),
+	}
+}
+
+// reads the settings with Read and validates all of them, as serving needs
+func Load() (Config, error) {
+	cfg, err := Read()
 	if err != nil {
-		return nil, err
+		return cfg, err
 	}
+	return cfg, cfg.Validate()
+}
+
+// exports the .env file named by ENV_FILE (default .env), reads the YAML file named by CONFIG_FILE when set
+// and applies the environment over it. Nothing is validated, commands check what they use.
+func Read() (Config, error) {
+	cfg := Default()
 
-	_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = ".env"
+	}
+	if err := loadDotEnv(envFile); err != nil {
+		return cfg, err
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, cfg.applyEnv()
+}
+
+// unknown keys are rejected so a misspelt setting does not silently keep its default
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer file.Close()
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
-		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-		name TEXT,
-		email TEXT,
-		username TEXT,
-		password TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		last_login TIMESTAMP,
-		is_active BOOLEAN,
-		groups TEXT[], 
-		metadata JSONB
-	)`)
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) applyEnv() error {
+	stringEnv("LISTEN_ADDR", &cfg.Server.Addr)
+	stringEnv("TLS_CERT_FILE", &cfg.Server.TLSCertFile)
+	stringEnv("TLS_KEY_FILE", &cfg.Server.TLSKeyFile)
+	secretEnv("DATABASE_URL", &cfg.Database.URL)
+
+	return errors.Join(
+		durationEnv("SERVER_READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout, "5s"),
+		durationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout, "15s"),
+		durationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout, "30s"),
+		durationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout, "60s"),
+		intEnv("SERVER_MAX_HEADER_BYTES", &cfg.Server.MaxHeaderBytes, "65536"),
+		durationEnv("SERVER_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout, "30s"),
+		intEnv("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns, "25"),
+		intEnv("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns, "5"),
+		durationEnv("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime, "30m"),
+		durationEnv("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime, "5m"),
+		durationEnv("DB_CONNECT_TIMEOUT", &cfg.Database.ConnectTimeout, "1m"),
+		durationEnv("DB_REQUEST_TIMEOUT", &cfg.Database.RequestTimeout, "10s"),
+		cfg.Auth.applyEnv(),
+		cfg.Password.applyEnv(),
+		cfg.Mail.applyEnv(),
+		cfg.RateLimit.applyEnv(),
+		cfg.Retention.applyEnv(),
+	)
+}
+
+// reports every invalid setting at once, naming both its file key and its variable
+func (cfg Config) Validate() error {
+	var v validator
+
+	v.check(cfg.Server.Addr != "", "server.addr (LISTEN_ADDR) must not be empty")
+	v.check((cfg.Server.TLSCertFile == "") == (cfg.Server.TLSKeyFile == ""), "server.tls_cert_file (TLS_CERT_FILE) and server.tls_key_file (TLS_KEY_FILE) must be set together")
+	v.positive(cfg.Server.ReadHeaderTimeout, "server.read_header_timeout (SERVER_READ_HEADER_TIMEOUT)", "5s")
+	v.positive(cfg.Server.ReadTimeout, "server.read_timeout (SERVER_READ_TIMEOUT)", "15s")
+	v.positive(cfg.Server.WriteTimeout, "server.write_timeout (SERVER_WRITE_TIMEOUT)", "30s")
+	v.positive(cfg.Server.IdleTimeout, "server.idle_timeout (SERVER_IDLE_TIMEOUT)", "60s")
+	v.check(cfg.Server.MaxHeaderBytes > 0, "server.max_header_bytes (SERVER_MAX_HEADER_BYTES) must be a positive number such as 65536")
+	v.positive(cfg.Server.ShutdownTimeout, "server.shutdown_timeout (SERVER_SHUTDOWN_TIMEOUT)", "30s")
+
+	cfg.Database.validate(&v)
+	v.check(cfg.Database.RequestTimeout < cfg.Server.WriteTimeout, "database.request_timeout (DB_REQUEST_TIMEOUT) must be shorter than server.write_timeout, or timed out requests cannot be answered")
+
+	cfg.Auth.validate(&v)
+	cfg.Password.validate(&v)
+	cfg.Mail.validate(&v)
+	cfg.RateLimit.validate(&v)
+	cfg.Retention.validate(&v)
+
+	return errors.Join(v.errs...)
+}
+
+// reports every invalid database setting, all the migrate command needs
+func (cfg Config) ValidateDatabase() error {
+	var v validator
+	cfg.Database.validate(&v)
+	return errors.Join(v.errs...)
+}
+
+func (cfg DatabaseConfig) validate(v *validator) {
+	v.check(cfg.URL != "", "database.url (DATABASE_URL) must be set")
+	v.check(cfg.MaxOpenConns > 0, "database.max_open_conns (DB_MAX_OPEN_CONNS) must be a positive number such as 25")
+	v.check(cfg.MaxIdleConns >= 0 && cfg.MaxIdleConns <= cfg.MaxOpenConns, "database.max_idle_conns (DB_MAX_IDLE_CONNS) must be between 0 and database.max_open_conns")
+	v.check(cfg.ConnMaxLifetime >= 0, "database.conn_max_lifetime (DB_CONN_MAX_LIFETIME) must not be negative")
+	v.check(cfg.ConnMaxIdleTime >= 0, "database.conn_max_idle_time (DB_CONN_MAX_IDLE_TIME) must not be negative")
+	v.positive(cfg.ConnectTimeout, "database.connect_timeout (DB_CONNECT_TIMEOUT)", "1m")
+	v.positive(cfg.RequestTimeout, "database.request_timeout (DB_REQUEST_TIMEOUT)", "10s")
+}
+
+// renders the effective settings as YAML with every secret redacted, safe to log
+func (cfg Config) Dump() string {
+	out, err := yaml.Marshal(cfg)
 	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+// opens the configured database with its pool limits and waits for it to answer, the schema is
+// managed by the migrations package
+func ConnectDatabase(cfg DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", string(cfg.URL))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err := waitForDatabase(db, cfg.ConnectTimeout); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
+
+// pings with exponential backoff, the database container often starts after the server. Each ping
+// shares the deadline, so a host that never answers cannot hold a single attempt past it.
+func waitForDatabase(db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	delay := connectRetryDelay
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("database unreachable after %s: %w", timeout, err)
+		}
+
+		log.Printf("Database not ready, retrying in %s: %v", delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, maxConnectRetryDelay)
+	}
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, message string) {
+	if !ok {
+		v.errs = append(v.errs, errors.New(message))
+	}
+}
+
+func (v *validator) positive(d time.Duration, name, example string) {
+	v.check(d > 0, fmt.Sprintf("%s must be a positive duration such as %s", name, example))
+}
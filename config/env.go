@@ -0,0 +1,113 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The env helpers below override a setting when its variable is set. They only check the syntax,
+// ranges are checked once every source is applied, by Validate.
+
+func stringEnv(name string, dst *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = value
+	}
+}
+
+func secretEnv(name string, dst *Secret) {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = Secret(value)
+	}
+}
+
+func intEnv(name string, dst *int, example string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a number such as %s", name, example)
+	}
+	*dst = n
+	return nil
+}
+
+func boolEnv(name string, dst *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false", name)
+	}
+	*dst = b
+	return nil
+}
+
+func durationEnv(name string, dst *time.Duration, example string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as %s", name, example)
+	}
+	*dst = d
+	return nil
+}
+
+// reads a limit written as count/period, such as 20/1m
+func rateEnv(name string, dst *RateLimit, example string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	limit, ok := parseRateLimit(value)
+	if !ok {
+		return fmt.Errorf("%s must be a count per period such as %s", name, example)
+	}
+	*dst = limit
+	return nil
+}
+
+// exports the KEY=VALUE lines of a .env file, variables already set in the environment win.
+// A missing file is not an error.
+func loadDotEnv(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"net/mail"
+	"os"
+	"time"
+)
+
+const (
+	defaultSMTPPort         = 587
+	defaultMailFrom         = "GoBerry <no-reply@localhost>"
+	defaultPasswordResetTTL = time.Hour
+
+	defaultEmailVerificationTTL      = 48 * time.Hour
+	defaultEmailVerificationCooldown = 5 * time.Minute
+)
+
+type MailConfig struct {
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword Secret `yaml:"smtp_password"`
+	From         string `yaml:"from"`
+	// LogFile receives messages instead of an SMTP relay when SMTPHost is empty, stdout when blank
+	LogFile string `yaml:"log_file"`
+	// PasswordResetURL is the page reset emails link to, the token is appended as a query parameter
+	PasswordResetURL string        `yaml:"password_reset_url"`
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl"`
+	// EmailVerificationURL is the page verification emails link to, the token is appended as a query parameter
+	EmailVerificationURL string        `yaml:"email_verification_url"`
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl"`
+	// EmailVerificationCooldown is how long to wait before another verification email goes to the same account
+	EmailVerificationCooldown time.Duration `yaml:"email_verification_cooldown"`
+}
+
+func defaultMailConfig() MailConfig {
+	return MailConfig{
+		SMTPPort:                  defaultSMTPPort,
+		From:                      defaultMailFrom,
+		PasswordResetTTL:          defaultPasswordResetTTL,
+		EmailVerificationTTL:      defaultEmailVerificationTTL,
+		EmailVerificationCooldown: defaultEmailVerificationCooldown,
+	}
+}
+
+// reads the mail settings from SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM,
+// MAIL_LOG_FILE, PASSWORD_RESET_URL, PASSWORD_RESET_TTL, EMAIL_VERIFICATION_URL, EMAIL_VERIFICATION_TTL
+// and EMAIL_VERIFICATION_COOLDOWN
+func (cfg *MailConfig) applyEnv() error {
+	stringEnv("SMTP_HOST", &cfg.SMTPHost)
+	stringEnv("SMTP_USERNAME", &cfg.SMTPUsername)
+	secretEnv("SMTP_PASSWORD", &cfg.SMTPPassword)
+	stringEnv("MAIL_LOG_FILE", &cfg.LogFile)
+	stringEnv("PASSWORD_RESET_URL", &cfg.PasswordResetURL)
+	stringEnv("EMAIL_VERIFICATION_URL", &cfg.EmailVerificationURL)
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		cfg.From = from
+	}
+
+	return errors.Join(
+		intEnv("SMTP_PORT", &cfg.SMTPPort, "587"),
+		durationEnv("PASSWORD_RESET_TTL", &cfg.PasswordResetTTL, "1h"),
+		durationEnv("EMAIL_VERIFICATION_TTL", &cfg.EmailVerificationTTL, "48h"),
+		durationEnv("EMAIL_VERIFICATION_COOLDOWN", &cfg.EmailVerificationCooldown, "5m"),
+	)
+}
+
+func (cfg MailConfig) validate(v *validator) {
+	v.check(cfg.SMTPPort > 0 && cfg.SMTPPort <= 65535, "mail.smtp_port (SMTP_PORT) must be a port number such as 587")
+	_, err := mail.ParseAddress(cfg.From)
+	v.check(err == nil, "mail.from (MAIL_FROM) must be an address such as GoBerry <no-reply@example.com>")
+	v.positive(cfg.PasswordResetTTL, "mail.password_reset_ttl (PASSWORD_RESET_TTL)", "1h")
+	v.positive(cfg.EmailVerificationTTL, "mail.email_verification_ttl (EMAIL_VERIFICATION_TTL)", "48h")
+	v.check(cfg.EmailVerificationCooldown >= 0, "mail.email_verification_cooldown (EMAIL_VERIFICATION_COOLDOWN) must not be negative")
+}
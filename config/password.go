@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordMaxLength = bcryptMaxPasswordLength
+	// bcrypt refuses to hash anything longer, in bytes
+	bcryptMaxPasswordLength = 72
+)
+
+type PasswordConfig struct {
+	// BcryptCost only applies to passwords hashed from now on, existing hashes keep their cost
+	BcryptCost       int  `yaml:"bcrypt_cost"`
+	MinLength        int  `yaml:"min_length"`
+	MaxLength        int  `yaml:"max_length"`
+	RequireUppercase bool `yaml:"require_uppercase"`
+	RequireLowercase bool `yaml:"require_lowercase"`
+	RequireNumber    bool `yaml:"require_number"`
+	RequireSpecial   bool `yaml:"require_special"`
+}
+
+func defaultPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		BcryptCost:       bcrypt.DefaultCost,
+		MinLength:        defaultPasswordMinLength,
+		MaxLength:        defaultPasswordMaxLength,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireNumber:    true,
+		RequireSpecial:   true,
+	}
+}
+
+// reads the password policy from BCRYPT_COST, PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and the
+// PASSWORD_REQUIRE_UPPERCASE, PASSWORD_REQUIRE_LOWERCASE, PASSWORD_REQUIRE_NUMBER and PASSWORD_REQUIRE_SPECIAL switches
+func (cfg *PasswordConfig) applyEnv() error {
+	return errors.Join(
+		intEnv("BCRYPT_COST", &cfg.BcryptCost, "10"),
+		intEnv("PASSWORD_MIN_LENGTH", &cfg.MinLength, "8"),
+		intEnv("PASSWORD_MAX_LENGTH", &cfg.MaxLength, "72"),
+		boolEnv("PASSWORD_REQUIRE_UPPERCASE", &cfg.RequireUppercase),
+		boolEnv("PASSWORD_REQUIRE_LOWERCASE", &cfg.RequireLowercase),
+		boolEnv("PASSWORD_REQUIRE_NUMBER", &cfg.RequireNumber),
+		boolEnv("PASSWORD_REQUIRE_SPECIAL", &cfg.RequireSpecial),
+	)
+}
+
+func (cfg PasswordConfig) validate(v *validator) {
+	v.check(cfg.BcryptCost >= bcrypt.MinCost && cfg.BcryptCost <= bcrypt.MaxCost,
+		fmt.Sprintf("password.bcrypt_cost (BCRYPT_COST) must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	v.check(cfg.MinLength > 0, "password.min_length (PASSWORD_MIN_LENGTH) must be a positive number such as 8")
+	v.check(cfg.MaxLength >= cfg.MinLength, "password.max_length (PASSWORD_MAX_LENGTH) must not be below password.min_length")
+	v.check(cfg.MaxLength <= bcryptMaxPasswordLength,
+		fmt.Sprintf("password.max_length (PASSWORD_MAX_LENGTH) must be at most %d, bcrypt cannot hash longer passwords", bcryptMaxPasswordLength))
+}
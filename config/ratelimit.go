@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimit allows Limit requests per Period, written as count/period such as 20/1m
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+func (r RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
+
+func parseRateLimit(value string) (RateLimit, bool) {
+	count, period, ok := strings.Cut(value, "/")
+	limit, err := strconv.Atoi(strings.TrimSpace(count))
+	d, perr := time.ParseDuration(strings.TrimSpace(period))
+	if !ok || err != nil || perr != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{Limit: limit, Period: d}, true
+}
+
+func (r RateLimit) MarshalYAML() (interface{}, error) {
+	return r.String(), nil
+}
+
+func (r *RateLimit) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	limit, ok := parseRateLimit(value)
+	if !ok {
+		return fmt.Errorf("rate limit %q must be a count per period such as 20/1m", value)
+	}
+	*r = limit
+	return nil
+}
+
+// RateLimitConfig holds the per-user allowance shared by authenticated routes and the per-address
+// allowances of the public auth routes
+type RateLimitConfig struct {
+	Default            RateLimit `yaml:"default"`
+	Login              RateLimit `yaml:"login"`
+	Refresh            RateLimit `yaml:"refresh"`
+	ForgotPassword     RateLimit `yaml:"forgot_password"`
+	ResetPassword      RateLimit `yaml:"reset_password"`
+	VerifyEmail        RateLimit `yaml:"verify_email"`
+	ResendVerification RateLimit `yaml:"resend_verification"`
+	Signup             RateLimit `yaml:"signup"`
+}
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Default:            RateLimit{Limit: 300, Period: time.Minute},
+		Login:              RateLimit{Limit: 20, Period: time.Minute},
+		Refresh:            RateLimit{Limit: 60, Period: time.Minute},
+		ForgotPassword:     RateLimit{Limit: 5, Period: time.Minute},
+		ResetPassword:      RateLimit{Limit: 10, Period: time.Minute},
+		VerifyEmail:        RateLimit{Limit: 10, Period: time.Minute},
+		ResendVerification: RateLimit{Limit: 5, Period: time.Minute},
+		Signup:             RateLimit{Limit: 10, Period: time.Minute},
+	}
+}
+
+// reads the limits from RATE_LIMIT_DEFAULT, RATE_LIMIT_LOGIN, RATE_LIMIT_REFRESH, RATE_LIMIT_FORGOT_PASSWORD,
+// RATE_LIMIT_RESET_PASSWORD, RATE_LIMIT_VERIFY_EMAIL, RATE_LIMIT_RESEND_VERIFICATION and RATE_LIMIT_SIGNUP
+func (cfg *RateLimitConfig) applyEnv() error {
+	return errors.Join(
+		rateEnv("RATE_LIMIT_DEFAULT", &cfg.Default, "300/1m"),
+		rateEnv("RATE_LIMIT_LOGIN", &cfg.Login, "20/1m"),
+		rateEnv("RATE_LIMIT_REFRESH", &cfg.Refresh, "60/1m"),
+		rateEnv("RATE_LIMIT_FORGOT_PASSWORD", &cfg.ForgotPassword, "5/1m"),
+		rateEnv("RATE_LIMIT_RESET_PASSWORD", &cfg.ResetPassword, "10/1m"),
+		rateEnv("RATE_LIMIT_VERIFY_EMAIL", &cfg.VerifyEmail, "10/1m"),
+		rateEnv("RATE_LIMIT_RESEND_VERIFICATION", &cfg.ResendVerification, "5/1m"),
+		rateEnv("RATE_LIMIT_SIGNUP", &cfg.Signup, "10/1m"),
+	)
+}
+
+func (cfg RateLimitConfig) validate(v *validator) {
+	for _, limit := range []struct {
+		name  string
+		limit RateLimit
+	}{
+		{"rate_limit.default (RATE_LIMIT_DEFAULT)", cfg.Default},
+		{"rate_limit.login (RATE_LIMIT_LOGIN)", cfg.Login},
+		{"rate_limit.refresh (RATE_LIMIT_REFRESH)", cfg.Refresh},
+		{"rate_limit.forgot_password (RATE_LIMIT_FORGOT_PASSWORD)", cfg.ForgotPassword},
+		{"rate_limit.reset_password (RATE_LIMIT_RESET_PASSWORD)", cfg.ResetPassword},
+		{"rate_limit.verify_email (RATE_LIMIT_VERIFY_EMAIL)", cfg.VerifyEmail},
+		{"rate_limit.resend_verification (RATE_LIMIT_RESEND_VERIFICATION)", cfg.ResendVerification},
+		{"rate_limit.signup (RATE_LIMIT_SIGNUP)", cfg.Signup},
+	} {
+		v.check(limit.limit.Limit > 0 && limit.limit.Period > 0, limit.name+" must allow a positive count per positive period such as 20/1m")
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultDeletedUserRetention = 30 * 24 * time.Hour
+	defaultPurgeInterval        = time.Hour
+)
+
+type RetentionConfig struct {
+	// DeletedUserRetention is how long soft-deleted users can be restored before they are purged for good
+	DeletedUserRetention time.Duration `yaml:"deleted_user_retention"`
+	// PurgeInterval is how often the purge looks for users past their retention
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+func defaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		DeletedUserRetention: defaultDeletedUserRetention,
+		PurgeInterval:        defaultPurgeInterval,
+	}
+}
+
+// reads the retention settings from DELETED_USER_RETENTION and PURGE_INTERVAL
+func (cfg *RetentionConfig) applyEnv() error {
+	return errors.Join(
+		durationEnv("DELETED_USER_RETENTION", &cfg.DeletedUserRetention, "720h"),
+		durationEnv("PURGE_INTERVAL", &cfg.PurgeInterval, "1h"),
+	)
+}
+
+func (cfg RetentionConfig) validate(v *validator) {
+	v.positive(cfg.DeletedUserRetention, "retention.deleted_user_retention (DELETED_USER_RETENTION)", "720h")
+	v.positive(cfg.PurgeInterval, "retention.purge_interval (PURGE_INTERVAL)", "1h")
+}
@@ -3,15 +3,18 @@ module go-berry
 go 1.21.6
 
 require (
-	github.com/DATA-DOG/go-sqlmock v1.5.2 // indirect
-	github.com/bxcodec/faker/v3 v3.8.1 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/bxcodec/faker/v3 v3.8.1
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.10.9
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/jaswdr/faker v1.19.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
-	golang.org/x/crypto v0.24.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
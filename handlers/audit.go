@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// attributes a change to the authenticated caller, when there is one, and the client that sent it
+func auditContext(r *http.Request) store.AuditContext {
+	audit := store.AuditContext{IPAddress: utils.ClientIP(r), UserAgent: r.UserAgent()}
+	if identity, ok := middleware.IdentityFromContext(r.Context()); ok {
+		actorID := identity.UserID
+		audit.ActorID = &actorID
+	}
+	return audit
+}
+
+// appends an event to the audit log. Pass the transaction of the change being recorded so both commit or neither does.
+func recordAuditEvent(ctx context.Context, db execer, r *http.Request, action string, targetID uuid.UUID, changes map[string]interface{}) error {
+	return store.InsertAuditEvent(ctx, db, auditContext(r).Event(action, targetID, changes))
+}
+
+// handles GET requests to page through the audit log, newest first, filtered by actor_id, target_user_id,
+// action and a from/to time range
+func GetAuditEvents(audits store.AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		page, limit, offset, ok := parsePagination(w, r)
+		if !ok {
+			return
+		}
+		filter, errs := parseAuditFilter(r)
+		if len(errs) > 0 {
+			utils.WriteValidationProblem(w, errs)
+			return
+		}
+		filter.Limit = limit
+		filter.Offset = offset
+
+		events, total, err := audits.List(ctx, filter)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying audit events", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := models.PaginatedAuditEventsResponse{
+			Events:      events,
+			Page:        page,
+			Limit:       limit,
+			TotalEvents: total,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func parseAuditFilter(r *http.Request) (store.AuditFilter, utils.ValidationErrors) {
+	query := r.URL.Query()
+	var filter store.AuditFilter
+	var errs utils.ValidationErrors
+
+	filter.ActorID = queryUUID(query, "actor_id", &errs)
+	filter.TargetUserID = queryUUID(query, "target_user_id", &errs)
+	filter.From = queryTime(query, "from", &errs)
+	filter.To = queryTime(query, "to", &errs)
+
+	if action := query.Get("action"); action != "" {
+		known := false
+		for _, a := range models.AuditActions {
+			known = known || a == action
+		}
+		if !known {
+			errs = append(errs, utils.FieldError{Field: "action", Message: "unknown action " + action})
+		}
+		filter.Action = action
+	}
+
+	return filter, errs
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserChangesAreAudited(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	adminID := uuid.New()
+
+	user := models.User{ID: uuid.New(), Name: "Berry", Email: "berry@example.com", IsActive: true}
+	users.Create(context.Background(), &user, store.AuditContext{})
+
+	req := newPatchRequest(user.ID, `{"name":"Blue Berry"}`, &middleware.Identity{UserID: adminID, IsAdmin: true})
+	req.Header.Set("User-Agent", "audit-test")
+	req.RemoteAddr = "192.0.2.7:40000"
+	rr := httptest.NewRecorder()
+
+	PatchUser(users).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	GetAuditEvents(users.Audit()).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/audit-events?action=user.updated&actor_id="+adminID.String(), nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.PaginatedAuditEventsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	if assert.Len(t, response.Events, 1) {
+		event := response.Events[0]
+		assert.Equal(t, user.ID, *event.TargetUserID)
+		assert.Equal(t, "192.0.2.7", event.IPAddress)
+		assert.Equal(t, "audit-test", event.UserAgent)
+		assert.Equal(t, map[string]interface{}{"from": "Berry", "to": "Blue Berry"}, event.Changes["name"])
+		assert.NotContains(t, event.Changes, "password", "Passwords are never recorded")
+	}
+	assert.Equal(t, 1, response.TotalEvents)
+}
+
+func TestGetAuditEventsValidatesFilters(t *testing.T) {
+	rr := httptest.NewRecorder()
+	GetAuditEvents(store.NewMemoryAuditStore()).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/audit-events?actor_id=nope&action=user.exploded&from=yesterday", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	assert.Contains(t, rr.Body.String(), `"code":"validation_failed"`)
+	assert.Contains(t, rr.Body.String(), `"field":"actor_id"`)
+	assert.Contains(t, rr.Body.String(), `"field":"action"`)
+	assert.Contains(t, rr.Body.String(), `"field":"from"`)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-berry/config"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// handles POST requests to exchange an email or username and a password for a token pair. Failures slow
+// down the account and the source address and eventually lock the account, unverified email addresses
+// are refused when the configuration requires them.
+func Login(db *sql.DB, tokens *utils.TokenManager, authConfig config.AuthConfig, attempts *utils.Throttle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var credentials models.LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		email := strings.TrimSpace(credentials.Email)
+		username := strings.TrimSpace(credentials.Username)
+		if (email == "" && username == "") || credentials.Password == "" {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "email or username, and password are required")
+			return
+		}
+
+		if !checkLoginThrottle(w, r, attempts) {
+			return
+		}
+
+		query := "SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL"
+		login := email
+		if email == "" {
+			query = "SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower(username) = lower($1) AND deleted_at IS NULL"
+			login = username
+		}
+
+		var userID uuid.UUID
+		var hashedPassword string
+		var isActive sql.NullBool
+		var totpEnabled, emailVerified bool
+		var failedAttempts int
+		var lockedUntil sql.NullTime
+		err := db.QueryRowContext(ctx, query, login).Scan(&userID, &hashedPassword, &isActive, &totpEnabled, &emailVerified, &failedAttempts, &lockedUntil)
+		if err != nil && err != sql.ErrNoRows {
+			middleware.Logger(ctx).Error("Error querying user", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err == sql.ErrNoRows {
+			dummyHash, err := utils.DummyPasswordHash()
+			if err != nil {
+				middleware.Logger(ctx).Error("Error hashing password", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+			utils.CheckPasswordHash(credentials.Password, dummyHash)
+			attempts.Fail(utils.ClientIP(r))
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+
+		if !checkAccountLock(w, authConfig, failedAttempts, lockedUntil) {
+			return
+		}
+
+		if !utils.CheckPasswordHash(credentials.Password, hashedPassword) {
+			if err := recordFailedLogin(db, r, authConfig, attempts, userID); err != nil {
+				middleware.Logger(ctx).Error("Error recording failed login", "error", err)
+			}
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+
+		if !isActive.Bool {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeAccountDisabled, "Account is disabled")
+			return
+		}
+
+		if authConfig.RequireVerifiedEmail && !emailVerified {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeEmailNotVerified, "Verify your email address before signing in")
+			return
+		}
+
+		// The password alone is not enough, hand out a challenge for the second factor
+		if totpEnabled {
+			mfaToken, err := tokens.Issue(userID.String(), utils.MFAToken, mfaChallengeTTL)
+			if err != nil {
+				middleware.Logger(ctx).Error("Error issuing mfa token", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+
+			response := models.MFAChallengeResponse{
+				MFARequired: true,
+				MFAToken:    mfaToken,
+				ExpiresIn:   int(mfaChallengeTTL.Seconds()),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		completeLogin(w, r, db, tokens, userID)
+	}
+}
+
+// records the login, clearing the account's failed attempts, and answers with a fresh session's token pair.
+// The source address keeps its failures: anyone holding one valid account could otherwise wipe them
+// after every guess at someone else's. They are forgotten once the address has been quiet for a while.
+func completeLogin(w http.ResponseWriter, r *http.Request, db *sql.DB, tokens *utils.TokenManager, userID uuid.UUID) {
+	ctx := r.Context()
+	var mustChangePassword bool
+	err := db.QueryRowContext(ctx, "UPDATE users SET last_login = $1, failed_login_attempts = 0, locked_until = NULL WHERE id = $2 RETURNING must_change_password", time.Now(), userID).Scan(&mustChangePassword)
+	if err != nil {
+		middleware.Logger(ctx).Error("Error updating last login", "error", err)
+		utils.WriteInternalError(w, r)
+		return
+	}
+
+	sessionID, refreshToken, err := createSession(db, tokens, userID, uuid.New(), r)
+	if err != nil {
+		middleware.Logger(ctx).Error("Error creating session", "error", err)
+		utils.WriteInternalError(w, r)
+		return
+	}
+
+	// The session is only good for changing the password until that is done
+	writeTokenResponseWith(w, r, tokens, userID, sessionID, refreshToken, models.TokenResponse{PasswordChangeRequired: mustChangePassword})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/config"
+	"go-berry/models"
+	"go-berry/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bxcodec/faker/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTokenManager() *utils.TokenManager {
+	return utils.NewTokenManager([]byte("0123456789abcdef0123456789abcdef"), 15*time.Minute, 24*time.Hour)
+}
+
+func newTestAuthConfig() config.AuthConfig {
+	return config.AuthConfig{MaxFailedLogins: 5, LockoutDuration: 15 * time.Minute, LoginDelay: time.Second}
+}
+
+func TestLogin(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	email := faker.Email()
+	hashedPassword, err := utils.HashPassword("StrongP@ssw0rd")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs(email).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(userID, hashedPassword, true, false, true, 0, nil))
+	mock.ExpectQuery("UPDATE users SET last_login = \\$1, failed_login_attempts = 0, locked_until = NULL WHERE id = \\$2 RETURNING must_change_password").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnRows(sqlmock.NewRows([]string{"must_change_password"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body, _ := json.Marshal(models.LoginRequest{Email: email, Password: "StrongP@ssw0rd"})
+	req, err := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	tokens := newTestTokenManager()
+	handler := Login(db, tokens, newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+
+	assert.Equal(t, "Bearer", response.TokenType)
+	assert.NotEmpty(t, response.RefreshToken, "A refresh token should be issued")
+	assert.Equal(t, 900, response.ExpiresIn)
+
+	claims, err := tokens.Parse(response.AccessToken, utils.AccessToken)
+	assert.NoError(t, err, "Access token should be valid")
+	assert.Equal(t, userID.String(), claims.Subject, "Access token should belong to the user")
+	assert.NotEmpty(t, claims.SessionID, "Access token should reference its session")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	hashedPassword, err := utils.HashPassword("StrongP@ssw0rd")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	userID := uuid.New()
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(username\\) = lower\\(\\$1\\)").
+		WithArgs("berry").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(userID, hashedPassword, true, false, true, 0, nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET failed_login_attempts = CASE WHEN failed_login_attempts >= \\$2 AND locked_until <= \\$3 THEN 1 ELSE failed_login_attempts \\+ 1 END WHERE id = \\$1 RETURNING failed_login_attempts").
+		WithArgs(userID, newTestAuthConfig().MaxFailedLogins, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+	mock.ExpectExec("UPDATE users SET locked_until = \\$1 WHERE id = \\$2").
+		WithArgs(nil, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.LoginRequest{Username: "berry", Password: "WrongP@ssw0rd"})
+	req, err := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler := Login(db, newTestTokenManager(), newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Should return status 401 Unauthorized")
+
+	// last_login must not be touched on a failed attempt, only the failure counter
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginRequiresVerifiedEmail(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	hashedPassword, err := utils.HashPassword("StrongP@ssw0rd")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(uuid.New(), hashedPassword, true, false, false, 0, nil))
+
+	body, _ := json.Marshal(models.LoginRequest{Email: "berry@example.com", Password: "StrongP@ssw0rd"})
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	authConfig := newTestAuthConfig()
+	authConfig.RequireVerifiedEmail = true
+	Login(db, newTestTokenManager(), authConfig, NewLoginThrottle(authConfig)).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Should return status 403 Forbidden")
+	assert.Contains(t, rr.Body.String(), `"code":"email_not_verified"`)
+
+	// No session is created for an unverified account
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"go-berry/middleware"
+	"go-berry/utils"
+)
+
+// answers 400 with the rejected fields, or 500 when validation itself failed
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrors utils.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		utils.WriteValidationProblem(w, validationErrors)
+		return
+	}
+	middleware.Logger(r.Context()).Error("Error validating input", "error", err)
+	utils.WriteInternalError(w, r)
+}
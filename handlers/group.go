@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// handles GET requests to retrieve all groups with pagination
+func GetAllGroups(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		page, limit, offset, ok := parsePagination(w, r)
+		if !ok {
+			return
+		}
+
+		// Fetch total number of groups for pagination metadata
+		var totalGroups int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM groups").Scan(&totalGroups)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error counting groups", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		rows, err := db.QueryContext(ctx, "SELECT id, name, description, created_at, updated_at, metadata FROM groups ORDER BY name LIMIT $1 OFFSET $2", limit, offset)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying groups", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+		defer rows.Close()
+
+		groups := []models.Group{}
+		for rows.Next() {
+			group, err := scanGroup(rows)
+			if err != nil {
+				middleware.Logger(ctx).Error("Error scanning group", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+			groups = append(groups, group)
+		}
+
+		if err := rows.Err(); err != nil {
+			middleware.Logger(ctx).Error("Error iterating over rows", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := models.PaginatedGroupsResponse{
+			Groups:      groups,
+			Page:        page,
+			Limit:       limit,
+			TotalGroups: totalGroups,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles GET requests to retrieve a single group by ID
+func GetGroup(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+
+		group, err := scanGroup(db.QueryRowContext(ctx, "SELECT id, name, description, created_at, updated_at, metadata FROM groups WHERE id = $1", id))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeGroupNotFound, "Group not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying group", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(group); err != nil {
+			middleware.Logger(ctx).Error("Error encoding response", "error", err)
+			utils.WriteInternalError(w, r)
+		}
+	}
+}
+
+// handles POST requests to create a new group
+func CreateGroup(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var group models.Group
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		group.ID = uuid.New()
+
+		if err := utils.ValidateGroupInput(ctx, &group, db); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		metadata, err := store.MarshalMetadata(group.Metadata)
+		if err != nil {
+			utils.WriteValidationProblem(w, utils.ValidationErrors{{Field: "metadata", Message: "metadata could not be encoded as JSON"}})
+			return
+		}
+
+		now := time.Now()
+		group.CreatedAt = now
+		group.UpdatedAt = now
+
+		_, err = db.ExecContext(
+			ctx,
+			"INSERT INTO groups (id, name, description, created_at, updated_at, metadata) VALUES ($1, $2, $3, $4, $5, $6)",
+			group.ID, group.Name, group.Description, group.CreatedAt, group.UpdatedAt, metadata,
+		)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error inserting group", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// handles PUT requests to update an existing group
+func UpdateGroup(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var group models.Group
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		id, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+		group.ID = id
+
+		if err := utils.ValidateGroupInput(ctx, &group, db); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		metadata, err := store.MarshalMetadata(group.Metadata)
+		if err != nil {
+			utils.WriteValidationProblem(w, utils.ValidationErrors{{Field: "metadata", Message: "metadata could not be encoded as JSON"}})
+			return
+		}
+
+		group.UpdatedAt = time.Now()
+
+		err = db.QueryRowContext(
+			ctx,
+			"UPDATE groups SET name = $1, description = $2, metadata = $3, updated_at = $4 WHERE id = $5 RETURNING created_at",
+			group.Name, group.Description, metadata, group.UpdatedAt, group.ID,
+		).Scan(&group.CreatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeGroupNotFound, "Group not found")
+			} else {
+				middleware.Logger(ctx).Error("Error updating group", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// handles DELETE requests to delete an existing group
+func DeleteGroup(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+
+		var name string
+		err := db.QueryRowContext(ctx, "DELETE FROM groups WHERE id = $1 RETURNING name", id).Scan(&name)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeGroupNotFound, "Group not found")
+			} else {
+				middleware.Logger(ctx).Error("Error deleting group", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("Group %s with ID %s deleted successfully", name, id),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGroup(row rowScanner) (models.Group, error) {
+	var group models.Group
+	var description sql.NullString
+	var metadata []byte
+
+	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &metadata)
+	if err != nil {
+		return group, err
+	}
+
+	group.Description = description.String
+	group.Metadata, err = store.UnmarshalMetadata(metadata)
+	return group, err
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAllGroups(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at", "metadata"}).
+		AddRow(uuid.New(), "admins", "Administrators", now, now, []byte(`{"tenant":"acme"}`)).
+		AddRow(uuid.New(), "support", nil, now, now, nil)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM groups").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	mock.ExpectQuery("SELECT id, name, description, created_at, updated_at, metadata FROM groups ORDER BY name LIMIT (.+) OFFSET (.+)").
+		WithArgs(5, 5).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/groups?page=2&limit=5", nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler := GetAllGroups(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var actualResponse models.PaginatedGroupsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&actualResponse); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+
+	assert.Equal(t, 2, actualResponse.Page, "The page should match")
+	assert.Equal(t, 5, actualResponse.Limit, "The limit should match")
+	assert.Equal(t, 2, actualResponse.TotalGroups, "The total number of groups should match")
+	assert.Len(t, actualResponse.Groups, 2, "The number of groups should match")
+	assert.Equal(t, "Administrators", actualResponse.Groups[0].Description, "Group description should match")
+	assert.Equal(t, "acme", actualResponse.Groups[0].Metadata["tenant"], "Group metadata should be decoded")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetGroupNotFound(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New()
+
+	mock.ExpectQuery("SELECT id, name, description, created_at, updated_at, metadata FROM groups WHERE id = \\$1").
+		WithArgs(groupID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at", "metadata"}))
+
+	req, err := http.NewRequest("GET", "/groups/"+groupID.String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID.String()})
+
+	rr := httptest.NewRecorder()
+
+	handler := GetGroup(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Should return status 404 Not Found")
+}
+
+func TestCreateGroup(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupInput := models.Group{
+		Name:        "engineering",
+		Description: "Engineering team",
+		Metadata:    map[string]interface{}{"tenant": "acme"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM groups WHERE name=\\$1 AND id<>\\$2\\)").
+		WithArgs(groupInput.Name, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	mock.ExpectExec("INSERT INTO groups").
+		WithArgs(sqlmock.AnyArg(), groupInput.Name, groupInput.Description, sqlmock.AnyArg(), sqlmock.AnyArg(), `{"tenant":"acme"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body, err := json.Marshal(groupInput)
+	if err != nil {
+		t.Fatalf("Error marshaling group input: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "/groups", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler := CreateGroup(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code, "Should return status 201 Created")
+
+	var responseGroup models.Group
+	if err := json.NewDecoder(rr.Body).Decode(&responseGroup); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+
+	assert.NotEqual(t, uuid.Nil, responseGroup.ID, "Group ID should be generated")
+	assert.Equal(t, groupInput.Name, responseGroup.Name)
+	assert.Equal(t, groupInput.Description, responseGroup.Description)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateGroupDuplicateName(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM groups WHERE name=\\$1 AND id<>\\$2\\)").
+		WithArgs("engineering", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	req, err := http.NewRequest(http.MethodPost, "/groups", bytes.NewBufferString(`{"name":"engineering"}`))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler := CreateGroup(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New()
+
+	mock.ExpectQuery("DELETE FROM groups WHERE id = \\$1 RETURNING name").
+		WithArgs(groupID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("support"))
+
+	req, err := http.NewRequest("DELETE", "/groups/"+groupID.String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID.String()})
+
+	rr := httptest.NewRecorder()
+
+	handler := DeleteGroup(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var actualResponse map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&actualResponse); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+
+	expectedMessage := fmt.Sprintf("Group support with ID %s deleted successfully", groupID)
+	assert.Equal(t, expectedMessage, actualResponse["message"], "Response message should match")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetGroupTimesOut(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New()
+
+	mock.ExpectQuery("SELECT id, name, description, created_at, updated_at, metadata FROM groups WHERE id = \\$1").
+		WithArgs(groupID.String()).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at", "metadata"}))
+
+	req, err := http.NewRequest("GET", "/groups/"+groupID.String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID.String()})
+
+	rr := httptest.NewRecorder()
+
+	handler := middleware.Deadline(20 * time.Millisecond)(GetGroup(db))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "Should return status 503 Service Unavailable")
+	assert.Contains(t, rr.Body.String(), `"code":"request_timeout"`)
+}
+
+func TestGroupRoutesRejectMalformedIDs(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New().String()
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		vars    map[string]string
+	}{
+		{"GetGroup", GetGroup(db), map[string]string{"id": "abc"}},
+		{"DeleteGroup", DeleteGroup(db), map[string]string{"id": "abc"}},
+		{"GetGroupMembers", GetGroupMembers(db), map[string]string{"id": "abc"}},
+		{"AddGroupMember", AddGroupMember(db), map[string]string{"id": groupID, "userId": "abc"}},
+		{"RemoveGroupMember", RemoveGroupMember(db), map[string]string{"id": "abc", "userId": uuid.New().String()}},
+		{"GetUserGroups", GetUserGroups(store.NewPostgresUserStore(db)), map[string]string{"id": "abc"}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = mux.SetURLVars(req, tc.vars)
+
+		rr := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code, "%s should return status 404 Not Found", tc.name)
+	}
+
+	// Malformed IDs are refused before the database is touched
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
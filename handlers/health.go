@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-berry/middleware"
+	"go-berry/migrations"
+	"go-berry/utils"
+)
+
+// bounds each readiness probe so a stalled database fails it instead of hanging it
+const readinessTimeout = 2 * time.Second
+
+// handles GET requests probing liveness, answering as long as the process serves requests
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// handles GET requests probing readiness, the database must answer and every migration must be applied
+func Readyz(db *sql.DB, migrator *migrations.Migrator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		if err := db.PingContext(ctx); err != nil {
+			middleware.Logger(ctx).Warn("Readiness check failed to reach the database", "error", err)
+			utils.WriteProblem(w, http.StatusServiceUnavailable, utils.CodeNotReady, "The database is unreachable")
+			return
+		}
+
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			middleware.Logger(ctx).Warn("Readiness check failed to read the applied migrations", "error", err)
+			utils.WriteProblem(w, http.StatusServiceUnavailable, utils.CodeNotReady, "The applied migrations could not be read")
+			return
+		}
+		if len(pending) > 0 {
+			utils.WriteProblem(w, http.StatusServiceUnavailable, utils.CodeNotReady, fmt.Sprintf("%d migrations are pending", len(pending)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+	}
+}
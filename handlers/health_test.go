@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-berry/migrations"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReadinessMock(t *testing.T) (sqlmock.Sqlmock, http.HandlerFunc) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("Error loading migrations: %v", err)
+	}
+	return mock, Readyz(db, migrator)
+}
+
+func TestHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Healthz().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	all, err := migrations.Load()
+	if err != nil {
+		t.Fatalf("Error loading migrations: %v", err)
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		mock, handler := newReadinessMock(t)
+		rows := sqlmock.NewRows([]string{"version", "applied_at"})
+		for _, migration := range all {
+			rows.AddRow(migration.Version, time.Now())
+		}
+		mock.ExpectPing()
+		mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(rows)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"status":"ready"}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("migrations pending", func(t *testing.T) {
+		mock, handler := newReadinessMock(t)
+		mock.ExpectPing()
+		mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(all[0].Version, time.Now()))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"not_ready"`)
+		assert.Contains(t, rr.Body.String(), "migrations are pending")
+	})
+
+	t.Run("database unreachable", func(t *testing.T) {
+		mock, handler := newReadinessMock(t)
+		mock.ExpectPing().WillReturnError(assert.AnError)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), "The database is unreachable")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Migrations should not be read without a database")
+	})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-berry/config"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// failures an account may accumulate before each further attempt has to wait
+	accountFreeLoginFailures = 2
+	// failures a source address may accumulate before it is slowed down, it may be shared by many users
+	ipFreeLoginFailures = 10
+)
+
+// tracks failed logins per source address, shared by the password and second factor steps
+func NewLoginThrottle(authConfig config.AuthConfig) *utils.Throttle {
+	return utils.NewThrottle(authConfig.LoginDelay, ipFreeLoginFailures, authConfig.LockoutDuration)
+}
+
+// answers 429 when the source address has to wait before trying again
+func checkLoginThrottle(w http.ResponseWriter, r *http.Request, attempts *utils.Throttle) bool {
+	if wait := attempts.Wait(utils.ClientIP(r)); wait > 0 {
+		writeRetryAfter(w, wait)
+		utils.WriteProblem(w, http.StatusTooManyRequests, utils.CodeTooManyAttempts, "Too many failed logins, try again later")
+		return false
+	}
+	return true
+}
+
+// answers 423 for locked accounts and 429 for accounts waiting out a delay, the password is not checked meanwhile
+func checkAccountLock(w http.ResponseWriter, authConfig config.AuthConfig, failedAttempts int, lockedUntil sql.NullTime) bool {
+	if !lockedUntil.Valid {
+		return true
+	}
+	wait := time.Until(lockedUntil.Time)
+	if wait <= 0 {
+		return true
+	}
+
+	writeRetryAfter(w, wait)
+	if failedAttempts >= authConfig.MaxFailedLogins {
+		utils.WriteProblem(w, http.StatusLocked, utils.CodeAccountLocked, "Account is locked after too many failed logins")
+	} else {
+		utils.WriteProblem(w, http.StatusTooManyRequests, utils.CodeTooManyAttempts, "Too many failed logins, try again later")
+	}
+	return false
+}
+
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// counts a failed password or second factor against the account and the source address, delaying the
+// account's next attempt and locking it once MaxFailedLogins is reached
+func recordFailedLogin(db *sql.DB, r *http.Request, authConfig config.AuthConfig, attempts *utils.Throttle, userID uuid.UUID) error {
+	// A client hanging up right after a wrong password must not dodge the count
+	ctx := context.WithoutCancel(r.Context())
+	attempts.Fail(utils.ClientIP(r))
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// A lockout that ran out starts the count over, otherwise the next wrong password would lock again at once
+	now := time.Now()
+	var failedAttempts int
+	err = tx.QueryRowContext(
+		ctx,
+		"UPDATE users SET failed_login_attempts = CASE WHEN failed_login_attempts >= $2 AND locked_until <= $3 THEN 1 ELSE failed_login_attempts + 1 END WHERE id = $1 RETURNING failed_login_attempts",
+		userID, authConfig.MaxFailedLogins, now,
+	).Scan(&failedAttempts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var lockedUntil sql.NullTime
+	if failedAttempts >= authConfig.MaxFailedLogins {
+		lockedUntil = sql.NullTime{Time: now.Add(authConfig.LockoutDuration), Valid: true}
+	} else if delay := utils.ProgressiveDelay(authConfig.LoginDelay, failedAttempts, accountFreeLoginFailures); delay > 0 {
+		lockedUntil = sql.NullTime{Time: now.Add(delay), Valid: true}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET locked_until = $1 WHERE id = $2", lockedUntil, userID)
+	if err == nil && failedAttempts >= authConfig.MaxFailedLogins {
+		middleware.Logger(ctx).Warn("User locked after too many failed logins", "locked_user_id", userID, "failed_login_attempts", failedAttempts)
+		err = recordAuditEvent(ctx, tx, r, models.AuditUserLocked, userID, map[string]interface{}{
+			"failed_login_attempts": failedAttempts,
+			"locked_until":          lockedUntil.Time,
+		})
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// handles POST requests from admins lifting a lockout before it runs out
+func UnlockUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		var failedAttempts int
+		var lockedUntil sql.NullTime
+		var isAdmin bool
+		err = tx.QueryRowContext(ctx, "SELECT failed_login_attempts, locked_until, is_admin FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID).Scan(&failedAttempts, &lockedUntil, &isAdmin)
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		var permissions []string
+		if !isAdmin {
+			permissions, err = store.EffectivePermissions(ctx, tx, userID)
+			if err != nil {
+				tx.Rollback()
+				middleware.Logger(ctx).Error("Error querying user permissions", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+		}
+		if !checkTargetPrivileges(w, r, isAdmin, permissions) {
+			tx.Rollback()
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1", userID)
+		if err == nil {
+			changes := map[string]interface{}{"failed_login_attempts": failedAttempts}
+			if lockedUntil.Valid {
+				changes["locked_until"] = lockedUntil.Time
+			}
+			err = recordAuditEvent(ctx, tx, r, models.AuditUserUnlocked, userID, changes)
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error unlocking user", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("User %s unlocked", userID),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLocksAccountAfterMaxFailures(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	hashedPassword, _ := utils.HashPassword("StrongP@ssw0rd")
+	authConfig := newTestAuthConfig()
+
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(userID, hashedPassword, true, false, true, 4, nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET failed_login_attempts = CASE WHEN failed_login_attempts >= \\$2 AND locked_until <= \\$3 THEN 1 ELSE failed_login_attempts \\+ 1 END WHERE id = \\$1 RETURNING failed_login_attempts").
+		WithArgs(userID, authConfig.MaxFailedLogins, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(authConfig.MaxFailedLogins))
+	mock.ExpectExec("UPDATE users SET locked_until = \\$1 WHERE id = \\$2").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserLocked, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.LoginRequest{Email: "berry@example.com", Password: "WrongP@ssw0rd"})
+	rr := httptest.NewRecorder()
+
+	Login(db, newTestTokenManager(), authConfig, NewLoginThrottle(authConfig)).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "The attempt that locks the account still fails as a wrong password")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginAfterLockoutExpires(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	hashedPassword, _ := utils.HashPassword("StrongP@ssw0rd")
+	authConfig := newTestAuthConfig()
+
+	// The lockout ran out, so the count starts over and the wrong password does not lock again
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(userID, hashedPassword, true, false, true, authConfig.MaxFailedLogins, time.Now().Add(-time.Minute)))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET failed_login_attempts = CASE WHEN failed_login_attempts >= \\$2 AND locked_until <= \\$3 THEN 1 ELSE failed_login_attempts \\+ 1 END WHERE id = \\$1 RETURNING failed_login_attempts").
+		WithArgs(userID, authConfig.MaxFailedLogins, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+	mock.ExpectExec("UPDATE users SET locked_until = \\$1 WHERE id = \\$2").
+		WithArgs(nil, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.LoginRequest{Email: "berry@example.com", Password: "WrongP@ssw0rd"})
+	rr := httptest.NewRecorder()
+
+	Login(db, newTestTokenManager(), authConfig, NewLoginThrottle(authConfig)).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "The first attempt after a lockout is an ordinary wrong password")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginRefusesLockedAccount(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	hashedPassword, _ := utils.HashPassword("StrongP@ssw0rd")
+	authConfig := newTestAuthConfig()
+	handler := Login(db, newTestTokenManager(), authConfig, NewLoginThrottle(authConfig))
+
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(uuid.New(), hashedPassword, true, false, true, 5, time.Now().Add(10*time.Minute)))
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(uuid.New(), hashedPassword, true, false, true, 3, time.Now().Add(2*time.Second)))
+
+	// Even the right password is refused while the account is locked
+	body, _ := json.Marshal(models.LoginRequest{Email: "berry@example.com", Password: "StrongP@ssw0rd"})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusLocked, rr.Code, "Should return status 423 Locked")
+	assert.Contains(t, rr.Body.String(), `"code":"account_locked"`)
+	assert.Equal(t, "600", rr.Header().Get("Retry-After"))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "An account waiting out a delay should get 429")
+	assert.Contains(t, rr.Body.String(), `"code":"too_many_attempts"`)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginThrottlesSourceAddress(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	authConfig := newTestAuthConfig()
+	attempts := NewLoginThrottle(authConfig)
+	for i := 0; i <= ipFreeLoginFailures; i++ {
+		attempts.Fail("192.0.2.1")
+	}
+
+	body, _ := json.Marshal(models.LoginRequest{Email: "berry@example.com", Password: "StrongP@ssw0rd"})
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+	req.RemoteAddr = "192.0.2.1:51234"
+	rr := httptest.NewRecorder()
+
+	Login(db, newTestTokenManager(), authConfig, attempts).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Should return status 429 Too Many Requests")
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	// The address is refused before the database is touched
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	adminID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT failed_login_attempts, locked_until, is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts", "locked_until", "is_admin"}).AddRow(5, time.Now().Add(10*time.Minute), false))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}))
+	mock.ExpectExec("UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = \\$1").
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), &adminID, models.AuditUserUnlocked, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/unlock", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	req = req.WithContext(middleware.WithIdentity(req.Context(), &middleware.Identity{UserID: adminID, IsAdmin: true}))
+	rr := httptest.NewRecorder()
+
+	UnlockUser(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUnlockDeletedUser(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+
+	// Soft-deleted users are filtered out, so the lookup finds nothing
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT failed_login_attempts, locked_until, is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts", "locked_until", "is_admin"}))
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/unlock", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	req = req.WithContext(middleware.WithIdentity(req.Context(), &middleware.Identity{UserID: uuid.New(), IsAdmin: true}))
+	rr := httptest.NewRecorder()
+
+	UnlockUser(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Deleted users cannot be unlocked")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
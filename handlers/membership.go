@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// handles POST requests to add a user to a group
+func AddGroupMember(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+		userID, ok := parseRouteID(w, r, "userId", utils.CodeUserNotFound, "User not found")
+		if !ok {
+			return
+		}
+
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM groups WHERE id=$1)", groupID, utils.CodeGroupNotFound, "Group not found") {
+			return
+		}
+		if !checkGroupRolesAccess(w, r, db, groupID) {
+			return
+		}
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL)", userID, utils.CodeUserNotFound, "User not found") {
+			return
+		}
+
+		result, err := db.ExecContext(
+			ctx,
+			"INSERT INTO user_groups (user_id, group_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			userID, groupID,
+		)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error adding group member", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		// Adding an existing member is not an error, it just does not create anything
+		status := http.StatusCreated
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			status = http.StatusOK
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("User %s is a member of group %s", userID, groupID),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles DELETE requests to remove a user from a group
+func RemoveGroupMember(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+		userID, ok := parseRouteID(w, r, "userId", utils.CodeUserNotFound, "User not found")
+		if !ok {
+			return
+		}
+
+		if !checkGroupRolesAccess(w, r, db, groupID) {
+			return
+		}
+
+		result, err := db.ExecContext(ctx, "DELETE FROM user_groups WHERE user_id = $1 AND group_id = $2", userID, groupID)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error removing group member", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			middleware.Logger(ctx).Error("Error removing group member", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+		if affected == 0 {
+			utils.WriteProblem(w, http.StatusNotFound, utils.CodeMembershipNotFound, "Membership not found")
+			return
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("User %s removed from group %s", userID, groupID),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles GET requests to retrieve the members of a group with pagination
+func GetGroupMembers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM groups WHERE id=$1)", groupID, utils.CodeGroupNotFound, "Group not found") {
+			return
+		}
+
+		page, limit, offset, ok := parsePagination(w, r)
+		if !ok {
+			return
+		}
+
+		var totalUsers int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_groups ug JOIN users u ON u.id = ug.user_id WHERE ug.group_id = $1 AND u.deleted_at IS NULL", groupID).Scan(&totalUsers)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error counting group members", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		rows, err := db.QueryContext(
+			ctx,
+			"SELECT u.id, u.name, u.email FROM users u JOIN user_groups ug ON ug.user_id = u.id WHERE ug.group_id = $1 AND u.deleted_at IS NULL ORDER BY u.name LIMIT $2 OFFSET $3",
+			groupID, limit, offset,
+		)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying group members", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+		defer rows.Close()
+
+		users := []models.User{}
+		for rows.Next() {
+			var user models.User
+			if err := rows.Scan(&user.ID, &user.Name, &user.Email); err != nil {
+				middleware.Logger(ctx).Error("Error scanning user", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+			users = append(users, user)
+		}
+
+		if err := rows.Err(); err != nil {
+			middleware.Logger(ctx).Error("Error iterating over rows", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := models.PaginatedResponse{
+			Users:      users,
+			Page:       page,
+			Limit:      limit,
+			TotalUsers: totalUsers,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles GET requests to retrieve the groups a user belongs to
+func GetUserGroups(users store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := users.Get(ctx, userID, false)
+		if err != nil {
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user groups", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user.Groups)
+	}
+}
+
+// runs an EXISTS query and answers 404 when it is false, reporting whether the handler may continue
+func checkExists(w http.ResponseWriter, r *http.Request, db *sql.DB, query string, id uuid.UUID, code, notFound string) bool {
+	var exists bool
+	if err := db.QueryRowContext(r.Context(), query, id).Scan(&exists); err != nil {
+		middleware.Logger(r.Context()).Error("Error checking existence", "error", err)
+		utils.WriteInternalError(w, r)
+		return false
+	}
+	if !exists {
+		utils.WriteProblem(w, http.StatusNotFound, code, notFound)
+		return false
+	}
+	return true
+}
+
+// answers 403 when the group carries roles and the caller may not manage roles. Members get every
+// permission of the group's roles, so groups:admin alone must not be a way to acquire them.
+func checkGroupRolesAccess(w http.ResponseWriter, r *http.Request, db *sql.DB, groupID uuid.UUID) bool {
+	if middleware.HasPermission(r.Context(), models.PermissionRolesAdmin) {
+		return true
+	}
+
+	var hasRoles bool
+	if err := db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM group_roles WHERE group_id=$1)", groupID).Scan(&hasRoles); err != nil {
+		middleware.Logger(r.Context()).Error("Error querying group roles", "error", err)
+		utils.WriteInternalError(w, r)
+		return false
+	}
+	if hasRoles {
+		utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Changing the members of a group with roles requires the roles:admin permission")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bxcodec/faker/v3"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddGroupMember(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New().String()
+	userID := uuid.New().String()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM groups WHERE id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM group_roles WHERE group_id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE id=\\$1 AND deleted_at IS NULL\\)").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("INSERT INTO user_groups \\(user_id, group_id\\) VALUES \\(\\$1, \\$2\\) ON CONFLICT DO NOTHING").
+		WithArgs(userID, groupID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req, err := http.NewRequest(http.MethodPost, "/groups/"+groupID+"/members/"+userID, nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID, "userId": userID})
+
+	rr := httptest.NewRecorder()
+
+	handler := AddGroupMember(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code, "Should return status 201 Created")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAddGroupMemberUnknownUser(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New().String()
+	userID := uuid.New().String()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM groups WHERE id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM group_roles WHERE group_id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE id=\\$1 AND deleted_at IS NULL\\)").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	req, err := http.NewRequest(http.MethodPost, "/groups/"+groupID+"/members/"+userID, nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID, "userId": userID})
+
+	rr := httptest.NewRecorder()
+
+	handler := AddGroupMember(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Should return status 404 Not Found")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAddGroupMemberToGroupWithRoles(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New().String()
+	callerID := uuid.New()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM groups WHERE id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM group_roles WHERE group_id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	req, err := http.NewRequest(http.MethodPost, "/groups/"+groupID+"/members/"+callerID.String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID, "userId": callerID.String()})
+	caller := &middleware.Identity{UserID: callerID, Permissions: map[string]bool{models.PermissionGroupsAdmin: true}}
+	req = req.WithContext(middleware.WithIdentity(req.Context(), caller))
+
+	rr := httptest.NewRecorder()
+
+	handler := AddGroupMember(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code, "groups:admin alone must not join a group that grants roles")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRemoveGroupMemberNotFound(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New().String()
+	userID := uuid.New().String()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM group_roles WHERE group_id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("DELETE FROM user_groups WHERE user_id = \\$1 AND group_id = \\$2").
+		WithArgs(userID, groupID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req, err := http.NewRequest(http.MethodDelete, "/groups/"+groupID+"/members/"+userID, nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID, "userId": userID})
+
+	rr := httptest.NewRecorder()
+
+	handler := RemoveGroupMember(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Should return status 404 Not Found")
+}
+
+func TestGetGroupMembers(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	groupID := uuid.New().String()
+	rows := sqlmock.NewRows([]string{"id", "name", "email"}).
+		AddRow(uuid.New(), faker.Name(), faker.Email()).
+		AddRow(uuid.New(), faker.Name(), faker.Email())
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM groups WHERE id=\\$1\\)").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM user_groups ug JOIN users u ON u.id = ug.user_id WHERE ug.group_id = \\$1 AND u.deleted_at IS NULL").
+		WithArgs(groupID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("SELECT u.id, u.name, u.email FROM users u JOIN user_groups ug ON ug.user_id = u.id WHERE ug.group_id = \\$1 AND u.deleted_at IS NULL").
+		WithArgs(groupID, 10, 0).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest(http.MethodGet, "/groups/"+groupID+"/members", nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": groupID})
+
+	rr := httptest.NewRecorder()
+
+	handler := GetGroupMembers(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var actualResponse models.PaginatedResponse
+	if err := json.NewDecoder(rr.Body).Decode(&actualResponse); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+
+	assert.Len(t, actualResponse.Users, 2, "The number of members should match")
+	assert.Equal(t, 2, actualResponse.TotalUsers, "The total number of members should match")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetUserGroups(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	user := models.User{ID: uuid.New(), Name: "Blue Berry", Email: "blue@example.com", Groups: []models.Group{{ID: uuid.New(), Name: "writers"}, {ID: uuid.New(), Name: "admins"}}}
+	users.Create(context.Background(), &user, store.AuditContext{})
+
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id+"/groups", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+		GetUserGroups(users).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(user.ID.String())
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+	var groups []models.Group
+	if err := json.NewDecoder(rr.Body).Decode(&groups); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, "admins", groups[0].Name, "Groups should be ordered by name")
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(uuid.New().String()).Code, "Should return status 404 Not Found")
+}
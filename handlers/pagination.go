@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// maxPageLimit is the most items a single page may hold, larger limits are lowered to it
+const maxPageLimit = 100
+
+// reads the page and limit query parameters, falling back to page 1 and 10 items and capping the
+// limit at maxPageLimit. Answers 400 when the page lies so far out that its offset would overflow.
+func parsePagination(w http.ResponseWriter, r *http.Request) (page, limit, offset int, ok bool) {
+	page = 1
+	limit = 10
+
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		p, err := strconv.Atoi(pageParam)
+		if err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		l, err := strconv.Atoi(limitParam)
+		if err == nil && l > 0 {
+			limit = min(l, maxPageLimit)
+		}
+	}
+
+	if page > math.MaxInt/limit {
+		utils.WriteValidationProblem(w, utils.ValidationErrors{{Field: "page", Message: "page is too large"}})
+		return 0, 0, 0, false
+	}
+
+	return page, limit, (page - 1) * limit, true
+}
+
+// cursor is the opaque position handed out as next_cursor, it remembers the direction of the walk
+// so a cursor cannot continue in the other one
+type cursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        uuid.UUID `json:"i"`
+	Desc      bool      `json:"d,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(user store.UserCursor, desc bool) string {
+	data, _ := json.Marshal(cursor{CreatedAt: user.CreatedAt, ID: user.ID, Desc: desc})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodes a next_cursor, an empty one starts the walk from the beginning
+func decodeCursor(value string, desc bool) (*store.UserCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == uuid.Nil || c.Desc != desc {
+		return nil, errInvalidCursor
+	}
+	return &store.UserCursor{CreatedAt: c.CreatedAt, ID: c.ID}, nil
+}
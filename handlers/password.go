@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-berry/config"
+	"go-berry/mailer"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// handles POST requests from users changing their own password, answering with a fresh token pair
+func ChangePassword(db *sql.DB, tokens *utils.TokenManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var request models.PasswordChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		if err := utils.ValidatePasswordInput("new_password", request.NewPassword); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		var hashedPassword string
+		err = tx.QueryRowContext(ctx, "SELECT password FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID).Scan(&hashedPassword)
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		if !utils.CheckPasswordHash(request.CurrentPassword, hashedPassword) {
+			tx.Rollback()
+			utils.WriteValidationProblem(w, utils.ValidationErrors{{Field: "current_password", Message: "current password is incorrect"}})
+			return
+		}
+		if request.NewPassword == request.CurrentPassword {
+			tx.Rollback()
+			utils.WriteValidationProblem(w, utils.ValidationErrors{{Field: "new_password", Message: "new password must differ from the current one"}})
+			return
+		}
+
+		err = setPassword(ctx, tx, userID, request.NewPassword, false)
+		if err == nil {
+			err = recordAuditEvent(ctx, tx, r, models.AuditUserPasswordChanged, userID, nil)
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error changing password", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		// Every other session was just revoked, the caller continues on a new one
+		sessionID, refreshToken, err := createSession(tx, tokens, userID, uuid.New(), r)
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error creating session", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		writeTokenResponse(w, r, tokens, userID, sessionID, refreshToken)
+	}
+}
+
+// handles POST requests from admins setting a temporary password the user must change at next login
+func ResetPassword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var request models.PasswordResetRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		if err := utils.ValidatePasswordInput("new_password", request.NewPassword); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		var isAdmin bool
+		var permissions []string
+		err = tx.QueryRowContext(ctx, "SELECT is_admin FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID).Scan(&isAdmin)
+		if err == nil && !isAdmin {
+			permissions, err = store.EffectivePermissions(ctx, tx, userID)
+		}
+		if err == nil && !checkTargetPrivileges(w, r, isAdmin, permissions) {
+			tx.Rollback()
+			return
+		}
+		if err == nil {
+			err = setPassword(ctx, tx, userID, request.NewPassword, true)
+		}
+		if err == nil {
+			err = recordAuditEvent(ctx, tx, r, models.AuditUserPasswordReset, userID, map[string]interface{}{"must_change_password": true})
+		}
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error resetting password", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := map[string]string{
+			"message": "Password reset, the user must change it at next login",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles POST requests to email a single-use password reset link, answering the same whether or not the email is registered
+func ForgotPassword(db *sql.DB, mail *mailer.Queue, mailConfig config.MailConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request models.ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		email := strings.TrimSpace(request.Email)
+		if email == "" {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "email is required")
+			return
+		}
+
+		// Failures are only logged, an error response would tell registered emails apart
+		if err := sendPasswordReset(ctx, db, mail, mailConfig, email); err != nil {
+			middleware.Logger(ctx).Error("Error sending password reset", "error", err)
+		}
+
+		response := map[string]string{
+			"message": "If the email is registered, a password reset link is on its way",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// issues a reset token for an active user and mails it in the background, so the response time does not depend on the relay
+func sendPasswordReset(ctx context.Context, db *sql.DB, mail *mailer.Queue, mailConfig config.MailConfig, email string) error {
+	var userID uuid.UUID
+	var name string
+	err := db.QueryRowContext(ctx, "SELECT id, name FROM users WHERE lower(email) = lower($1) AND is_active AND deleted_at IS NULL", email).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = db.ExecContext(
+		ctx,
+		"INSERT INTO password_reset_tokens (id, user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		uuid.New(), userID, utils.HashToken(token), now, now.Add(mailConfig.PasswordResetTTL),
+	)
+	if err != nil {
+		return err
+	}
+
+	message := mailer.Message{
+		To:      email,
+		Subject: "Reset your GoBerry password",
+		Body:    passwordResetBody(name, token, mailConfig),
+	}
+	logger := middleware.Logger(ctx)
+	mail.Send(message, func(err error) {
+		logger.Error("Error mailing password reset", "recipient_id", userID, "error", err)
+	})
+	return nil
+}
+
+func passwordResetBody(name, token string, mailConfig config.MailConfig) string {
+	instructions := "Use this token to choose a new password: " + token
+	if link, ok := tokenLink(mailConfig.PasswordResetURL, token); ok {
+		instructions = "Follow this link to choose a new password: " + link
+	}
+
+	return fmt.Sprintf(
+		"Hello %s,\n\nSomeone asked to reset the password of your GoBerry account.\n%s\n\nThe link expires in %s and works once. If you did not ask for it, you can ignore this email.\n",
+		name, instructions, mailConfig.PasswordResetTTL,
+	)
+}
+
+// appends the token to a configured page as a query parameter, false when no page is configured
+func tokenLink(page, token string) (string, bool) {
+	link, err := url.Parse(page)
+	if err != nil || page == "" {
+		return "", false
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), true
+}
+
+// handles POST requests that redeem a reset token for a new password
+func ResetForgottenPassword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request models.ResetForgottenPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		if strings.TrimSpace(request.Token) == "" {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "token is required")
+			return
+		}
+		if err := utils.ValidatePasswordInput("new_password", request.NewPassword); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		// Claiming the token and checking it are one statement, so it can only be redeemed once
+		now := time.Now()
+		var userID uuid.UUID
+		err = tx.QueryRowContext(
+			ctx,
+			"UPDATE password_reset_tokens SET used_at = $1 WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1 RETURNING user_id",
+			now, utils.HashToken(strings.TrimSpace(request.Token)),
+		).Scan(&userID)
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidToken, "Invalid or expired reset token")
+			} else {
+				middleware.Logger(ctx).Error("Error claiming reset token", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		// Older links stop working as well
+		_, err = tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL", now, userID)
+		if err == nil {
+			err = setPassword(ctx, tx, userID, request.NewPassword, false)
+		}
+		if err == nil {
+			err = recordAuditEvent(ctx, tx, r, models.AuditUserPasswordReset, userID, nil)
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error resetting password", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := map[string]string{
+			"message": "Password reset, sign in with the new password",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// stores a new password and revokes every session of the user, so tokens issued before stop working.
+// Returns sql.ErrNoRows when the user does not exist.
+func setPassword(ctx context.Context, tx *sql.Tx, userID uuid.UUID, password string, mustChange bool) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(
+		ctx,
+		"UPDATE users SET password = $1, must_change_password = $2, password_changed_at = $3, updated_at = $3 WHERE id = $4",
+		hashedPassword, mustChange, now, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", now, userID)
+	return err
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/config"
+	"go-berry/mailer"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangePassword(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	hashedPassword, _ := utils.HashPassword("StrongP@ssw0rd")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT password FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(hashedPassword))
+	mock.ExpectExec("UPDATE users SET password = \\$1, must_change_password = \\$2").
+		WithArgs(sqlmock.AnyArg(), false, sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE user_id = \\$2 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.AuditUserPasswordChanged, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.PasswordChangeRequest{CurrentPassword: "StrongP@ssw0rd", NewPassword: "N3wer&Str0nger"})
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/password", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	rr := httptest.NewRecorder()
+
+	tokens := newTestTokenManager()
+	ChangePassword(db, tokens).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	claims, err := tokens.Parse(response.AccessToken, utils.AccessToken)
+	assert.NoError(t, err, "A new access token should be issued")
+	assert.Equal(t, userID.String(), claims.Subject)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestChangePasswordWrongCurrentPassword(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	hashedPassword, _ := utils.HashPassword("StrongP@ssw0rd")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT password FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(hashedPassword))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.PasswordChangeRequest{CurrentPassword: "Wr0ng!Password", NewPassword: "N3wer&Str0nger"})
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/password", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	rr := httptest.NewRecorder()
+
+	ChangePassword(db, newTestTokenManager()).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	assert.Contains(t, rr.Body.String(), "current_password")
+
+	// A weak new password is refused before the database is touched
+	body, _ = json.Marshal(models.PasswordChangeRequest{CurrentPassword: "StrongP@ssw0rd", NewPassword: "weak"})
+	req = httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/password", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	rr = httptest.NewRecorder()
+
+	ChangePassword(db, newTestTokenManager()).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	assert.Contains(t, rr.Body.String(), "new_password")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}))
+	mock.ExpectExec("UPDATE users SET password = \\$1, must_change_password = \\$2").
+		WithArgs(sqlmock.AnyArg(), true, sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE user_id = \\$2 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.AuditUserPasswordReset, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.PasswordResetRequest{NewPassword: "Temp0rary!Pass"})
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/password/reset", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	rr := httptest.NewRecorder()
+
+	ResetPassword(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResetPasswordOfAdminNeedsAdmin(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	adminID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(adminID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.PasswordResetRequest{NewPassword: "Temp0rary!Pass"})
+	req := httptest.NewRequest(http.MethodPost, "/users/"+adminID.String()+"/password/reset", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": adminID.String()})
+	caller := &middleware.Identity{UserID: uuid.New(), Permissions: map[string]bool{models.PermissionUsersWrite: true}}
+	req = req.WithContext(middleware.WithIdentity(req.Context(), caller))
+	rr := httptest.NewRecorder()
+
+	ResetPassword(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code, "users:write alone must not reset an admin's password")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResetPasswordNeedsEveryPermissionOfTheTarget(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow(models.PermissionRolesAdmin).AddRow(models.PermissionUsersWrite))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.PasswordResetRequest{NewPassword: "Temp0rary!Pass"})
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/password/reset", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	caller := &middleware.Identity{UserID: uuid.New(), Permissions: map[string]bool{models.PermissionUsersWrite: true}}
+	req = req.WithContext(middleware.WithIdentity(req.Context(), caller))
+	rr := httptest.NewRecorder()
+
+	ResetPassword(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code, "users:write alone must not take over an account holding roles:admin")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+type recordingMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *recordingMailer) Send(message mailer.Message) error {
+	m.sent <- message
+	return nil
+}
+
+func TestForgotPassword(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	mail := &recordingMailer{sent: make(chan mailer.Message, 1)}
+	mailConfig := config.MailConfig{PasswordResetURL: "https://app.example.com/reset", PasswordResetTTL: time.Hour}
+	handler := ForgotPassword(db, mailer.NewQueue(mail), mailConfig)
+
+	mock.ExpectQuery("SELECT id, name FROM users WHERE lower\\(email\\) = lower\\(\\$1\\) AND is_active").
+		WithArgs("known@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(userID, "Known User"))
+	mock.ExpectExec("INSERT INTO password_reset_tokens").
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT id, name FROM users WHERE lower\\(email\\) = lower\\(\\$1\\) AND is_active").
+		WithArgs("unknown@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBufferString(`{"email":"known@example.com"}`)))
+	known := rr
+
+	select {
+	case message := <-mail.sent:
+		assert.Equal(t, "known@example.com", message.To)
+		assert.Contains(t, message.Body, "https://app.example.com/reset?token=", "The email should link to the reset page")
+	case <-time.After(time.Second):
+		t.Fatal("No reset email was sent")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBufferString(`{"email":"unknown@example.com"}`)))
+
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, rr.Code, "Unknown emails should get the same status")
+	assert.Equal(t, known.Body.String(), rr.Body.String(), "Unknown emails should get the same body")
+	assert.Empty(t, mail.sent, "Nothing should be sent for unknown emails")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResetForgottenPassword(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE password_reset_tokens SET used_at = \\$1 WHERE token_hash = \\$2 AND used_at IS NULL AND expires_at > \\$1 RETURNING user_id").
+		WithArgs(sqlmock.AnyArg(), utils.HashToken("reset-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(userID))
+	mock.ExpectExec("UPDATE password_reset_tokens SET used_at = \\$1 WHERE user_id = \\$2 AND used_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE users SET password = \\$1, must_change_password = \\$2").
+		WithArgs(sqlmock.AnyArg(), false, sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE user_id = \\$2 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.AuditUserPasswordReset, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// The same token a second time finds nothing left to claim
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE password_reset_tokens SET used_at = \\$1 WHERE token_hash = \\$2").
+		WithArgs(sqlmock.AnyArg(), utils.HashToken("reset-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.ResetForgottenPasswordRequest{Token: "reset-token", NewPassword: "N3wer&Str0nger"})
+	handler := ResetForgottenPassword(db)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/reset-password", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/reset-password", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Reset tokens should only work once")
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_token"`)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// The query helpers below read an optional parameter, returning the zero value when it is absent and
+// adding a field error when it is malformed, so every bad parameter is reported at once
+
+func queryUUID(query url.Values, name string, errs *utils.ValidationErrors) *uuid.UUID {
+	value := query.Get(name)
+	if value == "" {
+		return nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		*errs = append(*errs, utils.FieldError{Field: name, Message: name + " must be a UUID"})
+		return nil
+	}
+	return &id
+}
+
+func queryTime(query url.Values, name string, errs *utils.ValidationErrors) time.Time {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		*errs = append(*errs, utils.FieldError{Field: name, Message: name + " must be an RFC 3339 time such as 2024-06-01T12:00:00Z"})
+	}
+	return t
+}
+
+func queryBool(query url.Values, name string, errs *utils.ValidationErrors) *bool {
+	value := query.Get(name)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, utils.FieldError{Field: name, Message: name + " must be true or false"})
+		return nil
+	}
+	return &b
+}
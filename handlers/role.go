@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const roleSelect = `SELECT r.id, r.name, r.description, r.created_at, r.updated_at,
+	COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+	FROM roles r LEFT JOIN role_permissions rp ON rp.role_id = r.id`
+
+// handles GET requests to list the permissions roles may grant
+func GetPermissions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.Permissions)
+	}
+}
+
+// handles GET requests to retrieve all roles
+func GetAllRoles(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		roles, err := queryRoles(ctx, db, roleSelect+" GROUP BY r.id ORDER BY r.name")
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying roles", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roles)
+	}
+}
+
+// handles GET requests to retrieve a single role by ID
+func GetRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseRouteID(w, r, "id", utils.CodeRoleNotFound, "Role not found")
+		if !ok {
+			return
+		}
+
+		role, err := scanRole(db.QueryRowContext(ctx, roleSelect+" WHERE r.id = $1 GROUP BY r.id", id))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying role", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(role)
+	}
+}
+
+// handles POST requests to create a new role
+func CreateRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var role models.Role
+		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		role.ID = uuid.New()
+
+		if err := utils.ValidateRoleInput(ctx, &role, db); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		role.CreatedAt = now
+		role.UpdatedAt = now
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO roles (id, name, description, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)",
+			role.ID, role.Name, role.Description, role.CreatedAt, role.UpdatedAt,
+		)
+		if err == nil {
+			err = insertRolePermissions(ctx, tx, role)
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error inserting role", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(role)
+	}
+}
+
+// handles PUT requests to replace a role's name, description and permissions
+func UpdateRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var role models.Role
+		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		id, ok := parseRouteID(w, r, "id", utils.CodeRoleNotFound, "Role not found")
+		if !ok {
+			return
+		}
+		role.ID = id
+
+		if err := utils.ValidateRoleInput(ctx, &role, db); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		role.UpdatedAt = time.Now()
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		err = tx.QueryRowContext(
+			ctx,
+			"UPDATE roles SET name = $1, description = $2, updated_at = $3 WHERE id = $4 RETURNING created_at",
+			role.Name, role.Description, role.UpdatedAt, role.ID,
+		).Scan(&role.CreatedAt)
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+			} else {
+				middleware.Logger(ctx).Error("Error updating role", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role_id = $1", role.ID)
+		if err == nil {
+			err = insertRolePermissions(ctx, tx, role)
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error updating role permissions", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(role)
+	}
+}
+
+// handles DELETE requests to delete a role, which also removes it from every group
+func DeleteRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseRouteID(w, r, "id", utils.CodeRoleNotFound, "Role not found")
+		if !ok {
+			return
+		}
+
+		var name string
+		err := db.QueryRowContext(ctx, "DELETE FROM roles WHERE id = $1 RETURNING name", id).Scan(&name)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+			} else {
+				middleware.Logger(ctx).Error("Error deleting role", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("Role %s with ID %s deleted successfully", name, id),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles GET requests to retrieve the roles granted to a group
+func GetGroupRoles(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM groups WHERE id=$1)", groupID, utils.CodeGroupNotFound, "Group not found") {
+			return
+		}
+
+		roles, err := queryRoles(ctx, db, roleSelect+" JOIN group_roles gr ON gr.role_id = r.id WHERE gr.group_id = $1 GROUP BY r.id ORDER BY r.name", groupID)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying group roles", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roles)
+	}
+}
+
+// handles POST requests to grant a role to a group
+func AddGroupRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+		roleID, ok := parseRouteID(w, r, "roleId", utils.CodeRoleNotFound, "Role not found")
+		if !ok {
+			return
+		}
+
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM groups WHERE id=$1)", groupID, utils.CodeGroupNotFound, "Group not found") {
+			return
+		}
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM roles WHERE id=$1)", roleID, utils.CodeRoleNotFound, "Role not found") {
+			return
+		}
+
+		result, err := db.ExecContext(
+			ctx,
+			"INSERT INTO group_roles (group_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			groupID, roleID,
+		)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error granting group role", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		status := http.StatusCreated
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			status = http.StatusOK
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("Role %s is granted to group %s", roleID, groupID),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles DELETE requests to revoke a role from a group
+func RemoveGroupRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, ok := parseRouteID(w, r, "id", utils.CodeGroupNotFound, "Group not found")
+		if !ok {
+			return
+		}
+		roleID, ok := parseRouteID(w, r, "roleId", utils.CodeRoleNotFound, "Role not found")
+		if !ok {
+			return
+		}
+
+		result, err := db.ExecContext(ctx, "DELETE FROM group_roles WHERE group_id = $1 AND role_id = $2", groupID, roleID)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error revoking group role", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			middleware.Logger(ctx).Error("Error revoking group role", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+		if affected == 0 {
+			utils.WriteProblem(w, http.StatusNotFound, utils.CodeRoleNotGranted, "Role is not granted to this group")
+			return
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("Role %s revoked from group %s", roleID, groupID),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles GET requests to retrieve a user's effective permissions across their groups
+func GetUserPermissions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		if !checkExists(w, r, db, "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL)", userID, utils.CodeUserNotFound, "User not found") {
+			return
+		}
+
+		permissions, err := store.EffectivePermissions(ctx, db, userID)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying user permissions", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(permissions)
+	}
+}
+
+func queryRoles(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.Role, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func scanRole(row rowScanner) (models.Role, error) {
+	var role models.Role
+	var description sql.NullString
+
+	err := row.Scan(&role.ID, &role.Name, &description, &role.CreatedAt, &role.UpdatedAt, pq.Array(&role.Permissions))
+	role.Description = description.String
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	return role, err
+}
+
+func insertRolePermissions(ctx context.Context, tx *sql.Tx, role models.Role) error {
+	for _, permission := range role.Permissions {
+		_, err := tx.ExecContext(ctx, "INSERT INTO role_permissions (role_id, permission) VALUES ($1, $2)", role.ID, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/models"
+	"go-berry/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateRole(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM roles WHERE name=\\$1 AND id<>\\$2\\)").
+		WithArgs("support-agent", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO roles").
+		WithArgs(sqlmock.AnyArg(), "support-agent", "Reads user records", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO role_permissions").
+		WithArgs(sqlmock.AnyArg(), models.PermissionUsersRead).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// The duplicated permission is only stored once
+	body := `{"name":"support-agent","description":"Reads user records","permissions":["users:read","users:read"]}`
+	req, err := http.NewRequest(http.MethodPost, "/roles", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler := CreateRole(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code, "Should return status 201 Created")
+
+	var role models.Role
+	if err := json.NewDecoder(rr.Body).Decode(&role); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.Equal(t, []string{models.PermissionUsersRead}, role.Permissions)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateRoleUnknownPermission(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM roles WHERE name=\\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	body := `{"name":"superpowers","permissions":["users:fly"]}`
+	req, err := http.NewRequest(http.MethodPost, "/roles", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler := CreateRole(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+	var problem utils.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	assert.Equal(t, utils.CodeValidationFailed, problem.Code)
+	assert.Equal(t, []utils.FieldError{
+		{Field: "permissions", Message: `unknown permission "users:fly"`},
+		{Field: "name", Message: "role name is already taken"},
+	}, problem.Errors, "Every invalid field should be reported")
+}
+
+func TestGetRole(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	roleID := uuid.New()
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT r.id, r.name, r.description, r.created_at, r.updated_at,").
+		WithArgs(roleID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at", "permissions"}).
+			AddRow(roleID, "auditor", nil, now, now, pq.StringArray{"groups:admin", "users:read"}))
+
+	req, err := http.NewRequest(http.MethodGet, "/roles/"+roleID.String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": roleID.String()})
+
+	rr := httptest.NewRecorder()
+
+	handler := GetRole(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var role models.Role
+	if err := json.NewDecoder(rr.Body).Decode(&role); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.Equal(t, "auditor", role.Name)
+	assert.Equal(t, []string{"groups:admin", "users:read"}, role.Permissions)
+}
+
+func TestGetUserPermissions(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New().String()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE id=\\$1 AND deleted_at IS NULL\\)").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("groups:admin").AddRow("users:read"))
+
+	req, err := http.NewRequest(http.MethodGet, "/users/"+userID+"/permissions", nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": userID})
+
+	rr := httptest.NewRecorder()
+
+	handler := GetUserPermissions(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var permissions []string
+	if err := json.NewDecoder(rr.Body).Decode(&permissions); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.Equal(t, []string{"groups:admin", "users:read"}, permissions)
+}
+
+func TestRoleRoutesRejectMalformedIDs(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		vars    map[string]string
+	}{
+		{"GetRole", GetRole(db), map[string]string{"id": "abc"}},
+		{"DeleteRole", DeleteRole(db), map[string]string{"id": "abc"}},
+		{"GetGroupRoles", GetGroupRoles(db), map[string]string{"id": "abc"}},
+		{"AddGroupRole", AddGroupRole(db), map[string]string{"id": uuid.New().String(), "roleId": "abc"}},
+		{"RemoveGroupRole", RemoveGroupRole(db), map[string]string{"id": "abc", "roleId": uuid.New().String()}},
+		{"GetUserPermissions", GetUserPermissions(db), map[string]string{"id": "abc"}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = mux.SetURLVars(req, tc.vars)
+
+		rr := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code, "%s should return status 404 Not Found", tc.name)
+	}
+
+	// Malformed IDs are refused before the database is touched
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
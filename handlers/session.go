@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// handles POST requests to rotate a refresh token into a new token pair
+func RefreshToken(db *sql.DB, tokens *utils.TokenManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request models.RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		if strings.TrimSpace(request.RefreshToken) == "" {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "refresh_token is required")
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		var session models.Session
+		var rotatedAt, revokedAt sql.NullTime
+		var isActive sql.NullBool
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT s.id, s.user_id, s.family_id, s.expires_at, s.rotated_at, s.revoked_at, u.is_active FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.refresh_token_hash = $1 FOR UPDATE OF s",
+			utils.HashToken(request.RefreshToken),
+		).Scan(&session.ID, &session.UserID, &session.FamilyID, &session.ExpiresAt, &rotatedAt, &revokedAt, &isActive)
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidToken, "Invalid refresh token")
+			} else {
+				middleware.Logger(ctx).Error("Error querying session", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		now := time.Now()
+
+		// A token that was already rotated is being replayed, so the whole family is compromised
+		if rotatedAt.Valid {
+			_, err = tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL", now, session.FamilyID)
+			if err != nil {
+				tx.Rollback()
+				middleware.Logger(ctx).Error("Error revoking session family", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+			middleware.Logger(ctx).Warn("Refresh token reuse detected, all sessions of the family revoked", "family_id", session.FamilyID)
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidToken, "Invalid refresh token")
+			return
+		}
+
+		if revokedAt.Valid || !now.Before(session.ExpiresAt) || !isActive.Bool {
+			tx.Rollback()
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidToken, "Invalid refresh token")
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE sessions SET rotated_at = $1 WHERE id = $2", now, session.ID)
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error rotating session", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		sessionID, refreshToken, err := createSession(tx, tokens, session.UserID, session.FamilyID, r)
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error creating session", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		writeTokenResponse(w, r, tokens, session.UserID, sessionID, refreshToken)
+	}
+}
+
+// handles GET requests to list the active sessions (devices) of a user
+func GetUserSessions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		rows, err := db.QueryContext(
+			ctx,
+			"SELECT id, user_id, family_id, user_agent, ip_address, created_at, expires_at FROM sessions WHERE user_id = $1 AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > $2 ORDER BY created_at DESC",
+			userID, time.Now(),
+		)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying sessions", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+		defer rows.Close()
+
+		sessions := []models.Session{}
+		for rows.Next() {
+			var session models.Session
+			var userAgent, ipAddress sql.NullString
+			if err := rows.Scan(&session.ID, &session.UserID, &session.FamilyID, &userAgent, &ipAddress, &session.CreatedAt, &session.ExpiresAt); err != nil {
+				middleware.Logger(ctx).Error("Error scanning session", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+			session.UserAgent = userAgent.String
+			session.IPAddress = ipAddress.String
+			sessions = append(sessions, session)
+		}
+
+		if err := rows.Err(); err != nil {
+			middleware.Logger(ctx).Error("Error iterating over rows", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// handles DELETE requests to revoke a session together with the rest of its family
+func DeleteSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseRouteID(w, r, "id", utils.CodeSessionNotFound, "Session not found")
+		if !ok {
+			return
+		}
+
+		identity, ok := middleware.IdentityFromContext(r.Context())
+		if !ok {
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		// Users may only revoke their own sessions, admins may revoke any of them
+		query := "UPDATE sessions SET revoked_at = $1 WHERE family_id = (SELECT family_id FROM sessions WHERE id = $2 AND user_id = $3) AND revoked_at IS NULL"
+		args := []interface{}{time.Now(), id, identity.UserID}
+		if identity.IsAdmin {
+			query = "UPDATE sessions SET revoked_at = $1 WHERE family_id = (SELECT family_id FROM sessions WHERE id = $2) AND revoked_at IS NULL"
+			args = args[:2]
+		}
+
+		result, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error revoking session", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			middleware.Logger(ctx).Error("Error revoking session", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+		if affected == 0 {
+			utils.WriteProblem(w, http.StatusNotFound, utils.CodeSessionNotFound, "Session not found")
+			return
+		}
+
+		response := map[string]string{
+			"message": fmt.Sprintf("Session %s revoked successfully", id),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// stores a new hashed refresh token and returns the session ID with the raw token
+func createSession(db execer, tokens *utils.TokenManager, userID, familyID uuid.UUID, r *http.Request) (uuid.UUID, string, error) {
+	ctx := r.Context()
+	refreshToken, err := utils.GenerateRandomToken()
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	sessionID := uuid.New()
+	now := time.Now()
+	_, err = db.ExecContext(
+		ctx,
+		"INSERT INTO sessions (id, user_id, family_id, refresh_token_hash, user_agent, ip_address, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		sessionID, userID, familyID, utils.HashToken(refreshToken), r.UserAgent(), utils.ClientIP(r), now, now.Add(tokens.RefreshTTL()),
+	)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	return sessionID, refreshToken, nil
+}
+
+func writeTokenResponse(w http.ResponseWriter, r *http.Request, tokens *utils.TokenManager, userID, sessionID uuid.UUID, refreshToken string) {
+	writeTokenResponseWith(w, r, tokens, userID, sessionID, refreshToken, models.TokenResponse{})
+}
+
+// fills the tokens into a response that may already carry flags for the client
+func writeTokenResponseWith(w http.ResponseWriter, r *http.Request, tokens *utils.TokenManager, userID, sessionID uuid.UUID, refreshToken string, response models.TokenResponse) {
+	accessToken, err := tokens.IssueAccessToken(userID, sessionID)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("Error issuing access token", "error", err)
+		utils.WriteInternalError(w, r)
+		return
+	}
+
+	response.AccessToken = accessToken
+	response.RefreshToken = refreshToken
+	response.TokenType = "Bearer"
+	response.ExpiresIn = int(tokens.AccessTTL().Seconds())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var sessionColumns = []string{"id", "user_id", "family_id", "expires_at", "rotated_at", "revoked_at", "is_active"}
+
+func newRefreshRequest(t *testing.T, refreshToken string) *http.Request {
+	body, _ := json.Marshal(models.RefreshRequest{RefreshToken: refreshToken})
+	req, err := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	return req
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	sessionID := uuid.New()
+	userID := uuid.New()
+	familyID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id, s.family_id, s.expires_at, s.rotated_at, s.revoked_at, u.is_active FROM sessions s").
+		WithArgs(utils.HashToken("old-refresh-token")).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).AddRow(sessionID, userID, familyID, time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectExec("UPDATE sessions SET rotated_at = \\$1 WHERE id = \\$2").
+		WithArgs(sqlmock.AnyArg(), sessionID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs(sqlmock.AnyArg(), userID, familyID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+
+	handler := RefreshToken(db, newTestTokenManager())
+	handler.ServeHTTP(rr, newRefreshRequest(t, "old-refresh-token"))
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.NotEmpty(t, response.AccessToken, "A new access token should be issued")
+	assert.NotEqual(t, "old-refresh-token", response.RefreshToken, "The refresh token should be rotated")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	familyID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id, s.family_id, s.expires_at, s.rotated_at, s.revoked_at, u.is_active FROM sessions s").
+		WithArgs(utils.HashToken("rotated-refresh-token")).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).AddRow(uuid.New(), uuid.New(), familyID, time.Now().Add(time.Hour), time.Now().Add(-time.Minute), nil, true))
+	mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE family_id = \\$2 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), familyID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+
+	handler := RefreshToken(db, newTestTokenManager())
+	handler.ServeHTTP(rr, newRefreshRequest(t, "rotated-refresh-token"))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Should return status 401 Unauthorized")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id, s.family_id, s.expires_at, s.rotated_at, s.revoked_at, u.is_active FROM sessions s").
+		WithArgs(utils.HashToken("expired-refresh-token")).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).AddRow(uuid.New(), uuid.New(), uuid.New(), time.Now().Add(-time.Minute), nil, nil, true))
+	mock.ExpectRollback()
+
+	rr := httptest.NewRecorder()
+
+	handler := RefreshToken(db, newTestTokenManager())
+	handler.ServeHTTP(rr, newRefreshRequest(t, "expired-refresh-token"))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Should return status 401 Unauthorized")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	sessionID := uuid.New().String()
+	userID := uuid.New()
+
+	mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE family_id = \\(SELECT family_id FROM sessions WHERE id = \\$2 AND user_id = \\$3\\) AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), sessionID, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req, err := http.NewRequest(http.MethodDelete, "/sessions/"+sessionID, nil)
+	if err != nil {
+		t.Fatalf("Error creating the HTTP request: %v", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": sessionID})
+	req = req.WithContext(middleware.WithIdentity(req.Context(), &middleware.Identity{UserID: userID}))
+
+	rr := httptest.NewRecorder()
+
+	handler := DeleteSession(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSessionRoutesRejectMalformedIDs(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	identity := &middleware.Identity{UserID: uuid.New(), IsAdmin: true}
+	for name, handler := range map[string]http.HandlerFunc{"GetUserSessions": GetUserSessions(db), "DeleteSession": DeleteSession(db)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+		req = req.WithContext(middleware.WithIdentity(req.Context(), identity))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code, "%s should return status 404 Not Found", name)
+	}
+
+	// Malformed IDs are refused before the database is touched
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
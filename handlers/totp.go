@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-berry/config"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "GoBerry"
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+)
+
+// clock used for one-time password checks, tests pin it to a fixed instant
+var clock = time.Now
+
+// handles POST requests to start TOTP enrollment, returning the secret to load into an authenticator
+func EnrollTOTP(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var email string
+		var totpEnabled bool
+		err := db.QueryRowContext(ctx, "SELECT email, totp_enabled FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&email, &totpEnabled)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		if totpEnabled {
+			utils.WriteProblem(w, http.StatusConflict, utils.CodeTOTPAlreadyEnabled, "Two-factor authentication is already enabled")
+			return
+		}
+
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			middleware.Logger(ctx).Error("Error generating totp secret", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		// The secret stays pending until a first code confirms the authenticator works
+		_, err = db.ExecContext(ctx, "UPDATE users SET totp_secret = $1, totp_last_step = NULL WHERE id = $2", secret, id)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error storing totp secret", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := models.TOTPEnrollmentResponse{
+			Secret:     secret,
+			OTPAuthURI: utils.TOTPURI(totpIssuer, email, secret),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles POST requests to confirm TOTP enrollment with a first code, returning recovery codes
+func ConfirmTOTP(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var request models.TOTPConfirmRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		var secret sql.NullString
+		var totpEnabled bool
+		err := db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&secret, &totpEnabled)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		if totpEnabled {
+			utils.WriteProblem(w, http.StatusConflict, utils.CodeTOTPAlreadyEnabled, "Two-factor authentication is already enabled")
+			return
+		}
+		if !secret.Valid {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeTOTPNotEnrolled, "Two-factor enrollment has not been started")
+			return
+		}
+
+		step, ok := utils.ValidateTOTP(secret.String, strings.TrimSpace(request.Code), clock())
+		if !ok {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidCode, "Invalid code")
+			return
+		}
+
+		recoveryCodes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error generating recovery codes", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2", step, id)
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error enabling totp", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", id)
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error deleting recovery codes", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		for _, code := range recoveryCodes {
+			_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", id, utils.HashToken(code))
+			if err != nil {
+				tx.Rollback()
+				middleware.Logger(ctx).Error("Error inserting recovery code", "error", err)
+				utils.WriteInternalError(w, r)
+				return
+			}
+		}
+
+		if err := recordAuditEvent(ctx, tx, r, models.AuditUserTOTPEnabled, id, nil); err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error recording audit event", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		// Recovery codes are only ever shown here, we keep just their hashes
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+	}
+}
+
+// handles POST requests that answer an mfa challenge with a TOTP or recovery code, wrong codes count as failed logins
+func LoginMFA(db *sql.DB, tokens *utils.TokenManager, authConfig config.AuthConfig, attempts *utils.Throttle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request models.MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		code := strings.TrimSpace(request.Code)
+		recoveryCode := utils.NormalizeRecoveryCode(request.RecoveryCode)
+		if request.MFAToken == "" || (code == "" && recoveryCode == "") {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "mfa_token and a code or recovery_code are required")
+			return
+		}
+
+		claims, err := tokens.Parse(request.MFAToken, utils.MFAToken)
+		if err != nil {
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidToken, "Invalid or expired mfa token")
+			return
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidToken, "Invalid or expired mfa token")
+			return
+		}
+
+		if !checkLoginThrottle(w, r, attempts) {
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		var secret sql.NullString
+		var lastStep sql.NullInt64
+		var isActive sql.NullBool
+		var failedAttempts int
+		var lockedUntil sql.NullTime
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT totp_secret, totp_last_step, is_active, failed_login_attempts, locked_until FROM users WHERE id = $1 AND totp_enabled AND deleted_at IS NULL FOR UPDATE",
+			userID,
+		).Scan(&secret, &lastStep, &isActive, &failedAttempts, &lockedUntil)
+		if err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidToken, "Invalid or expired mfa token")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		if !isActive.Bool {
+			tx.Rollback()
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeAccountDisabled, "Account is disabled")
+			return
+		}
+
+		if !checkAccountLock(w, authConfig, failedAttempts, lockedUntil) {
+			tx.Rollback()
+			return
+		}
+
+		invalidCode := func() {
+			tx.Rollback()
+			if err := recordFailedLogin(db, r, authConfig, attempts, userID); err != nil {
+				middleware.Logger(ctx).Error("Error recording failed login", "error", err)
+			}
+			utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeInvalidCode, "Invalid code")
+		}
+
+		if code != "" {
+			// Each code is accepted once, a replay within its window is refused
+			step, ok := utils.ValidateTOTP(secret.String, code, clock())
+			if !ok || (lastStep.Valid && step <= lastStep.Int64) {
+				invalidCode()
+				return
+			}
+			_, err = tx.ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2", step, userID)
+		} else {
+			var result sql.Result
+			result, err = tx.ExecContext(
+				ctx,
+				"UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+				clock(), userID, utils.HashToken(recoveryCode),
+			)
+			if err == nil {
+				if affected, _ := result.RowsAffected(); affected == 0 {
+					invalidCode()
+					return
+				}
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error recording second factor", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		completeLogin(w, r, db, tokens, userID)
+	}
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/models"
+	"go-berry/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// pins the handlers' clock for the duration of a test
+func useFixedClock(t *testing.T, at time.Time) {
+	previous := clock
+	clock = func() time.Time { return at }
+	t.Cleanup(func() { clock = previous })
+}
+
+func TestLoginReturnsMFAChallenge(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	hashedPassword, _ := utils.HashPassword("StrongP@ssw0rd")
+
+	mock.ExpectQuery("SELECT id, password, is_active, totp_enabled, email_verified_at IS NOT NULL, failed_login_attempts, locked_until FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_active", "totp_enabled", "email_verified", "failed_login_attempts", "locked_until"}).AddRow(userID, hashedPassword, true, true, true, 0, nil))
+
+	body, _ := json.Marshal(models.LoginRequest{Email: "berry@example.com", Password: "StrongP@ssw0rd"})
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+
+	rr := httptest.NewRecorder()
+
+	tokens := newTestTokenManager()
+	handler := Login(db, tokens, newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.MFAChallengeResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.True(t, response.MFARequired, "A second factor should be required")
+
+	claims, err := tokens.Parse(response.MFAToken, utils.MFAToken)
+	assert.NoError(t, err, "The challenge token should be valid")
+	assert.Equal(t, userID.String(), claims.Subject)
+
+	_, err = tokens.Parse(response.MFAToken, utils.AccessToken)
+	assert.Error(t, err, "The challenge token must not work as an access token")
+
+	// No session is created until the second factor is verified
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Unix(1111111111, 0)
+	useFixedClock(t, now)
+	code, _ := utils.TOTPCode(testTOTPSecret, now)
+	userID := uuid.New()
+
+	mock.ExpectQuery("SELECT totp_secret, totp_enabled FROM users WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_enabled"}).AddRow(testTOTPSecret, false))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET totp_enabled = TRUE, totp_last_step = \\$1 WHERE id = \\$2").
+		WithArgs(now.Unix()/30, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM recovery_codes WHERE user_id = \\$1").
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < recoveryCodeCount; i++ {
+		mock.ExpectExec("INSERT INTO recovery_codes").
+			WithArgs(userID, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.AuditUserTOTPEnabled, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.TOTPConfirmRequest{Code: code})
+	req, _ := http.NewRequest(http.MethodPost, "/users/"+userID.String()+"/2fa/totp/confirm", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+
+	rr := httptest.NewRecorder()
+
+	handler := ConfirmTOTP(db)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.RecoveryCodesResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.Len(t, response.RecoveryCodes, recoveryCodeCount, "Recovery codes should be returned once")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestConfirmTOTPRejectsMalformedIDs(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	// Postgres accepts both forms as UUIDs, the handler must not
+	for _, id := range []string{"a0ee-bc99-9c0b-4ef8-bb6d-6bb9-bd38-0a11", "{a0eebc999c0b4ef8bb6d6bb9bd380a11}"} {
+		body, _ := json.Marshal(models.TOTPConfirmRequest{Code: "123456"})
+		req, _ := http.NewRequest(http.MethodPost, "/users/"+id+"/2fa/totp/confirm", bytes.NewBuffer(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		rr := httptest.NewRecorder()
+		ConfirmTOTP(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code, "Should return status 404 Not Found for %s", id)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginMFAWithCode(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Unix(1234567890, 0)
+	useFixedClock(t, now)
+	code, _ := utils.TOTPCode(testTOTPSecret, now)
+
+	tokens := newTestTokenManager()
+	userID := uuid.New()
+	mfaToken, _ := tokens.Issue(userID.String(), utils.MFAToken, mfaChallengeTTL)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT totp_secret, totp_last_step, is_active, failed_login_attempts, locked_until FROM users WHERE id = \\$1 AND totp_enabled AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_last_step", "is_active", "failed_login_attempts", "locked_until"}).AddRow(testTOTPSecret, now.Unix()/30-5, true, 0, nil))
+	mock.ExpectExec("UPDATE users SET totp_last_step = \\$1 WHERE id = \\$2").
+		WithArgs(now.Unix()/30, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("UPDATE users SET last_login = \\$1, failed_login_attempts = 0, locked_until = NULL WHERE id = \\$2 RETURNING must_change_password").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnRows(sqlmock.NewRows([]string{"must_change_password"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO sessions").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body, _ := json.Marshal(models.MFALoginRequest{MFAToken: mfaToken, Code: code})
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa", bytes.NewBuffer(body))
+
+	rr := httptest.NewRecorder()
+
+	handler := LoginMFA(db, tokens, newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding response body: %v", err)
+	}
+	assert.NotEmpty(t, response.AccessToken, "An access token should be issued")
+	assert.NotEmpty(t, response.RefreshToken, "A refresh token should be issued")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginMFARejectsReplayedCode(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Unix(1234567890, 0)
+	useFixedClock(t, now)
+	code, _ := utils.TOTPCode(testTOTPSecret, now)
+
+	tokens := newTestTokenManager()
+	userID := uuid.New()
+	mfaToken, _ := tokens.Issue(userID.String(), utils.MFAToken, mfaChallengeTTL)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT totp_secret, totp_last_step, is_active, failed_login_attempts, locked_until FROM users WHERE id = \\$1 AND totp_enabled AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_last_step", "is_active", "failed_login_attempts", "locked_until"}).AddRow(testTOTPSecret, now.Unix()/30, true, 0, nil))
+	mock.ExpectRollback()
+	// The rejected code counts as a failed login
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET failed_login_attempts = CASE WHEN failed_login_attempts >= \\$2 AND locked_until <= \\$3 THEN 1 ELSE failed_login_attempts \\+ 1 END WHERE id = \\$1 RETURNING failed_login_attempts").
+		WithArgs(userID, newTestAuthConfig().MaxFailedLogins, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+	mock.ExpectExec("UPDATE users SET locked_until = \\$1 WHERE id = \\$2").
+		WithArgs(nil, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(models.MFALoginRequest{MFAToken: mfaToken, Code: code})
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa", bytes.NewBuffer(body))
+
+	rr := httptest.NewRecorder()
+
+	handler := LoginMFA(db, tokens, newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "A code that was already used should be rejected")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginMFAWithRecoveryCode(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	useFixedClock(t, time.Unix(1234567890, 0))
+
+	tokens := newTestTokenManager()
+	userID := uuid.New()
+	mfaToken, _ := tokens.Issue(userID.String(), utils.MFAToken, mfaChallengeTTL)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT totp_secret, totp_last_step, is_active, failed_login_attempts, locked_until FROM users WHERE id = \\$1 AND totp_enabled AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_last_step", "is_active", "failed_login_attempts", "locked_until"}).AddRow(testTOTPSecret, nil, true, 0, nil))
+	mock.ExpectExec("UPDATE recovery_codes SET used_at = \\$1 WHERE user_id = \\$2 AND code_hash = \\$3 AND used_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID, utils.HashToken("abcde-fghij")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.MFALoginRequest{MFAToken: mfaToken, RecoveryCode: "ABCDE FGHIJ"})
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa", bytes.NewBuffer(body))
+
+	rr := httptest.NewRecorder()
+
+	handler := LoginMFA(db, tokens, newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "An unknown or used recovery code should be rejected")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginMFAForDeletedUser(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Unix(1111111111, 0)
+	useFixedClock(t, now)
+	code, _ := utils.TOTPCode(testTOTPSecret, now)
+
+	tokens := newTestTokenManager()
+	userID := uuid.New()
+	mfaToken, _ := tokens.Issue(userID.String(), utils.MFAToken, mfaChallengeTTL)
+
+	// The user was deleted after the password step, so the row no longer matches
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT totp_secret, totp_last_step, is_active, failed_login_attempts, locked_until FROM users WHERE id = \\$1 AND totp_enabled AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_last_step", "is_active", "failed_login_attempts", "locked_until"}))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.MFALoginRequest{MFAToken: mfaToken, Code: code})
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa", bytes.NewBuffer(body))
+
+	rr := httptest.NewRecorder()
+
+	handler := LoginMFA(db, tokens, newTestAuthConfig(), NewLoginThrottle(newTestAuthConfig()))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "A deleted user should not finish logging in")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
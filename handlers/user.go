@@ -1,86 +1,66 @@
 package handlers
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
+	"mime"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"go-berry/config"
+	"go-berry/mailer"
+	"go-berry/middleware"
 	"go-berry/models"
+	"go-berry/store"
 	"go-berry/utils"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-// handles GET requests to retrieve all users with pagination
-func GetAllUsers(db *sql.DB) http.HandlerFunc {
+// handles GET requests to retrieve all users with pagination, filtered by email, username, is_active, group,
+// created_from/created_to and last_login_from/last_login_to, searched with q and ordered by sort. A cursor
+// parameter switches from page numbers to keyset pagination.
+func GetAllUsers(users store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		// Get pagination parameters from query
-		pageParam := r.URL.Query().Get("page")
-		limitParam := r.URL.Query().Get("limit")
-
-		// Set default values if parameters are not provided
-		page := 1
-		limit := 10
-
-		if pageParam != "" {
-			p, err := strconv.Atoi(pageParam)
-			if err == nil && p > 0 {
-				page = p
-			}
-		}
-
-		if limitParam != "" {
-			l, err := strconv.Atoi(limitParam)
-			if err == nil && l > 0 {
-				limit = l
-			}
+		page, limit, offset, ok := parsePagination(w, r)
+		if !ok {
+			return
 		}
-
-		offset := (page - 1) * limit
-
-		// Fetch total number of users for pagination metadata
-		var totalUsers int
-		err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&totalUsers)
-		if err != nil {
-			log.Printf("Error counting users: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		includeDeleted, ok := parseIncludeDeleted(w, r)
+		if !ok {
 			return
 		}
-
-		// Fetch users from database
-		rows, err := db.Query("SELECT id, name, email FROM users LIMIT $1 OFFSET $2", limit, offset)
-		if err != nil {
-			log.Printf("Error querying users: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		opts, errs := parseUserListOptions(r)
+		if len(errs) > 0 {
+			utils.WriteValidationProblem(w, errs)
 			return
 		}
-		defer rows.Close()
+		opts.IncludeDeleted = includeDeleted
 
-		users := []models.User{}
-		for rows.Next() {
-			var user models.User
-			if err := rows.Scan(&user.ID, &user.Name, &user.Email); err != nil {
-				log.Printf("Error scanning user: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-			users = append(users, user)
+		if r.URL.Query().Has("cursor") {
+			getUsersByCursor(w, r, users, opts, limit)
+			return
 		}
 
-		if err := rows.Err(); err != nil {
-			log.Printf("Error iterating over rows: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		opts.Limit = limit
+		opts.Offset = offset
+		list, totalUsers, err := users.List(ctx, opts)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error querying users", "error", err)
+			utils.WriteInternalError(w, r)
 			return
 		}
 
 		// Respond with JSON including pagination metadata
 		response := models.PaginatedResponse{
-			Users:      users,
+			Users:      list,
 			Page:       page,
 			Limit:      limit,
 			TotalUsers: totalUsers,
@@ -92,21 +72,25 @@ func GetAllUsers(db *sql.DB) http.HandlerFunc {
 }
 
 // handles GET requests to retrieve a single user by ID
-func GetUser(db *sql.DB) http.HandlerFunc {
+func GetUser(users store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		ctx := r.Context()
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+		includeDeleted, ok := parseIncludeDeleted(w, r)
+		if !ok {
+			return
+		}
 
-		var user models.User
-		err := db.QueryRow("SELECT id, name, email, created_at, updated_at, is_active FROM users WHERE id = $1", id).Scan(
-			&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.IsActive,
-		)
+		user, err := users.Get(ctx, id, includeDeleted)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.Error(w, "User not found", http.StatusNotFound)
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
 			} else {
-				log.Printf("Error querying user: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
 			}
 			return
 		}
@@ -116,36 +100,37 @@ func GetUser(db *sql.DB) http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(user); err != nil {
-			log.Printf("Error encoding response: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			middleware.Logger(ctx).Error("Error encoding response", "error", err)
+			utils.WriteInternalError(w, r)
 		}
 	}
 }
 
-// handles POST requests to create a new user
-func CreateUser(db *sql.DB) http.HandlerFunc {
+// handles POST requests to create a new user and mail them a link to verify their email address
+func CreateUser(users store.UserStore, tokens *utils.TokenManager, mail *mailer.Queue, mailConfig config.MailConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		var user models.User
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
 			return
 		}
 
-		if err := utils.ValidateUserInput(&user, db, false); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if err := utils.ValidateUserInput(ctx, &user, users, nil); err != nil {
+			writeValidationError(w, r, err)
 			return
 		}
 
 		hashedPassword, err := utils.HashPassword(user.Password)
 		if err != nil {
-			log.Printf("Error hashing password: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			middleware.Logger(ctx).Error("Error hashing password", "error", err)
+			utils.WriteInternalError(w, r)
 			return
 		}
 
 		match := utils.CheckPasswordHash(user.Password, hashedPassword)
 		if !match {
-			http.Error(w, "Error hashing password", http.StatusInternalServerError)
+			utils.WriteInternalError(w, r)
 			return
 		}
 		user.Password = hashedPassword
@@ -156,30 +141,18 @@ func CreateUser(db *sql.DB) http.HandlerFunc {
 		user.CreatedAt = now
 		user.UpdatedAt = now
 		user.IsActive = true
+		// The address is only trusted once the link sent to it is followed
+		user.EmailVerifiedAt = nil
 
-		// Use a transaction for atomicity
-		tx, err := db.Begin()
-		if err != nil {
-			log.Printf("Error beginning transaction: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
-		_, err = tx.Exec(
-			"INSERT INTO users (id, name, email, password, created_at, updated_at, is_active) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-			user.ID, user.Name, user.Email, user.Password, user.CreatedAt, user.UpdatedAt, user.IsActive,
-		)
-		if err != nil {
-			tx.Rollback()
-			log.Printf("Error inserting user: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if err := users.Create(ctx, &user, auditContext(r)); err != nil {
+			middleware.Logger(ctx).Error("Error inserting user", "error", err)
+			utils.WriteInternalError(w, r)
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
-			log.Printf("Error committing transaction: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+		// The account exists either way, a failed email can be sent again from the resend endpoint
+		if err := sendEmailVerification(ctx, tokens, mail, mailConfig, user.ID, user.Name, user.Email); err != nil {
+			middleware.Logger(ctx).Error("Error sending email verification", "error", err)
 		}
 
 		// Do not include the password in the response
@@ -193,102 +166,224 @@ func CreateUser(db *sql.DB) http.HandlerFunc {
 }
 
 // handles PUT requests to update an existing user
-func UpdateUser(db *sql.DB) http.HandlerFunc {
+func UpdateUser(users store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		var user models.User
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
 			return
 		}
 
-		if err := utils.ValidateUserInput(&user, db, true); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-}
-
-		vars := mux.Vars(r)
-		id := vars["id"]
-
-		now := time.Now()
-		user.UpdatedAt = now
-
-		// Use a transaction for atomicity
-		tx, err := db.Begin()
-		if err != nil {
-			log.Printf("Error beginning transaction: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+		if !checkStoredTargetPrivileges(w, r, users, id) {
 			return
 		}
 
-		_, err = tx.Exec(
-			"UPDATE users SET name = $1, email = $2, updated_at = $3 WHERE id = $4",
-			user.Name, user.Email, user.UpdatedAt, id,
-		)
+		current, err := users.Get(ctx, id, false)
 		if err != nil {
-			tx.Rollback()
-			log.Printf("Error updating user: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
-			log.Printf("Error committing transaction: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if err := utils.ValidateUserInput(ctx, &user, users, current); err != nil {
+			writeValidationError(w, r, err)
 			return
 		}
 
-		// Do not include the password in the response
-		user.Password = ""
+		stored, err := users.Update(ctx, id, store.UserUpdate{Name: &user.Name, Email: &user.Email, UpdatedAt: time.Now()}, auditContext(r))
+		if err != nil {
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error updating user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
 
 		// Respond with JSON
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(user)
+		json.NewEncoder(w).Encode(stored)
 	}
 }
 
-
-// handles DELETE requests to delete an existing user
-func DeleteUser(db *sql.DB) http.HandlerFunc {
+// handles PATCH requests to update some fields of a user with a JSON merge patch (RFC 7396)
+func PatchUser(users store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		ctx := r.Context()
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
 
-		var user models.User
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+			utils.WriteProblem(w, http.StatusUnsupportedMediaType, utils.CodeUnsupportedMediaType, "Send the patch as application/merge-patch+json")
+			return
+		}
 
-		// Use a transaction for atomicity
-		tx, err := db.Begin()
-		if err != nil {
-			log.Printf("Error beginning transaction: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		var patch map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "The patch must be a JSON object")
+			return
+		}
+
+		// Activating or deactivating an account is not something users may do to themselves
+		if _, ok := patch["is_active"]; ok && !middleware.HasPermission(r.Context(), models.PermissionUsersWrite) {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Changing is_active requires the users:write permission")
+			return
+		}
+
+		// Deactivating an account or moving its email under the caller's control is as good as taking it over
+		if !checkStoredTargetPrivileges(w, r, users, id) {
 			return
 		}
 
-		// Fetch the user details before deletion
-		err = tx.QueryRow("SELECT name, email FROM users WHERE id = $1", id).Scan(&user.Name, &user.Email)
+		current, err := users.Get(ctx, id, false)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.Error(w, "User not found", http.StatusNotFound)
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
 			} else {
-				log.Printf("Error querying user: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				middleware.Logger(ctx).Error("Error querying user", "error", err)
+				utils.WriteInternalError(w, r)
 			}
-			tx.Rollback()
 			return
 		}
 
-		_, err = tx.Exec("DELETE FROM users WHERE id = $1", id)
+		patched, update, fields, errs := applyUserPatch(current, patch)
+		if len(errs) > 0 {
+			utils.WriteValidationProblem(w, errs)
+			return
+		}
+		if err := utils.ValidateUserPatch(ctx, &patched, current, fields, users); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		update.UpdatedAt = time.Now()
+		stored, err := users.Update(ctx, id, update, auditContext(r))
 		if err != nil {
-			log.Printf("Error deleting user: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			tx.Rollback()
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error updating user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+		stored.Groups = current.Groups
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(stored)
+	}
+}
+
+// merges a patch into a copy of the user, collecting the store update and the fields it touches
+func applyUserPatch(current *models.User, patch map[string]json.RawMessage) (models.User, store.UserUpdate, map[string]bool, utils.ValidationErrors) {
+	patched := *current
+	var update store.UserUpdate
+	var errs utils.ValidationErrors
+	fields := map[string]bool{}
+
+	// Sorted so errors come back in a stable order
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		raw := patch[name]
+		isNull := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+		fields[name] = true
+
+		switch name {
+		case "name", "email":
+			var value string
+			if isNull || json.Unmarshal(raw, &value) != nil {
+				errs = append(errs, utils.FieldError{Field: name, Message: name + " must be a string"})
+				continue
+			}
+			if name == "name" {
+				patched.Name = value
+				update.Name = &patched.Name
+			} else {
+				patched.Email = value
+				update.Email = &patched.Email
+			}
+		case "username":
+			// null removes the username
+			var value string
+			if !isNull && json.Unmarshal(raw, &value) != nil {
+				errs = append(errs, utils.FieldError{Field: name, Message: "username must be a string or null"})
+				continue
+			}
+			patched.Username = value
+			update.Username = &patched.Username
+		case "is_active":
+			var value bool
+			if isNull || json.Unmarshal(raw, &value) != nil {
+				errs = append(errs, utils.FieldError{Field: name, Message: "is_active must be a boolean"})
+				continue
+			}
+			patched.IsActive = value
+			update.IsActive = &patched.IsActive
+		case "metadata":
+			var value interface{}
+			if json.Unmarshal(raw, &value) != nil {
+				errs = append(errs, utils.FieldError{Field: name, Message: "metadata must be an object or null"})
+				continue
+			}
+			if _, ok := value.(map[string]interface{}); !ok && value != nil {
+				errs = append(errs, utils.FieldError{Field: name, Message: "metadata must be an object or null"})
+				continue
+			}
+			// Members are merged into the existing metadata, null clears it entirely
+			patched.Metadata = nil
+			if value != nil {
+				patched.Metadata = utils.MergePatch(current.Metadata, value).(map[string]interface{})
+			}
+			update.Metadata = &patched.Metadata
+		default:
+			errs = append(errs, utils.FieldError{Field: name, Message: name + " cannot be changed with PATCH"})
+		}
+	}
+
+	return patched, update, fields, errs
+}
+
+// handles DELETE requests to soft-delete a user, it can be restored until the purge removes it
+func DeleteUser(users store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		if !checkStoredTargetPrivileges(w, r, users, id) {
 			return
 		}
 
-		// Commit the transaction
-		if err := tx.Commit(); err != nil {
-			log.Printf("Error committing transaction: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		user, err := users.Delete(ctx, id, auditContext(r))
+		if err != nil {
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+			} else {
+				middleware.Logger(ctx).Error("Error deleting user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
 			return
 		}
 
@@ -301,3 +396,185 @@ func DeleteUser(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+// handles POST requests from admins bringing back a soft-deleted user
+func RestoreUser(users store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, ok := parseUserID(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := users.Restore(ctx, id, auditContext(r))
+		if err != nil {
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "No deleted user with this ID")
+			} else {
+				middleware.Logger(ctx).Error("Error restoring user", "error", err)
+				utils.WriteInternalError(w, r)
+			}
+			return
+		}
+
+		// Do not include the password in the response
+		user.Password = ""
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// answers a keyset page, used when the request carries a cursor parameter, empty for the first page.
+// Walks follow created_at, oldest first or newest first with sort=-created_at, and include_total=false
+// skips counting the matching users.
+func getUsersByCursor(w http.ResponseWriter, r *http.Request, users store.UserStore, opts store.ListOptions, limit int) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	var errs utils.ValidationErrors
+
+	desc := false
+	switch {
+	case len(opts.Sort) == 0:
+	case len(opts.Sort) == 1 && opts.Sort[0].Field == "created_at":
+		desc = opts.Sort[0].Desc
+	default:
+		errs = append(errs, utils.FieldError{Field: "sort", Message: "cursor pagination can only sort by created_at or -created_at"})
+	}
+	after, err := decodeCursor(query.Get("cursor"), desc)
+	if err != nil {
+		errs = append(errs, utils.FieldError{Field: "cursor", Message: "cursor must be a next_cursor returned for the same sort"})
+	}
+	includeTotal := queryBool(query, "include_total", &errs)
+	if len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
+		return
+	}
+
+	// One extra user tells whether another page follows
+	opts.After = after
+	opts.Limit = limit + 1
+	opts.SkipCount = includeTotal != nil && !*includeTotal
+	list, totalUsers, err := users.List(ctx, opts)
+	if err != nil {
+		middleware.Logger(ctx).Error("Error querying users", "error", err)
+		utils.WriteInternalError(w, r)
+		return
+	}
+
+	response := models.CursorPaginatedResponse{Users: list, Limit: limit}
+	if len(list) > limit {
+		response.Users = list[:limit]
+		last := list[limit-1]
+		response.NextCursor = encodeCursor(store.UserCursor{CreatedAt: last.CreatedAt, ID: last.ID}, desc)
+	}
+	if !opts.SkipCount {
+		response.TotalUsers = &totalUsers
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func parseUserListOptions(r *http.Request) (store.ListOptions, utils.ValidationErrors) {
+	query := r.URL.Query()
+	var errs utils.ValidationErrors
+
+	opts := store.ListOptions{
+		Email:         strings.TrimSpace(query.Get("email")),
+		Username:      strings.TrimSpace(query.Get("username")),
+		IsActive:      queryBool(query, "is_active", &errs),
+		GroupID:       queryUUID(query, "group", &errs),
+		CreatedFrom:   queryTime(query, "created_from", &errs),
+		CreatedTo:     queryTime(query, "created_to", &errs),
+		LastLoginFrom: queryTime(query, "last_login_from", &errs),
+		LastLoginTo:   queryTime(query, "last_login_to", &errs),
+		Query:         strings.TrimSpace(query.Get("q")),
+	}
+
+	// sort=-created_at,name orders by newest first, then by name
+	if sort := query.Get("sort"); sort != "" {
+		for _, field := range strings.Split(sort, ",") {
+			field = strings.TrimSpace(field)
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			if !store.IsUserSortField(field) {
+				errs = append(errs, utils.FieldError{Field: "sort", Message: fmt.Sprintf("cannot sort by %q, use one of %s", field, strings.Join(store.UserSortFields, ", "))})
+				continue
+			}
+			opts.Sort = append(opts.Sort, store.SortField{Field: field, Desc: desc})
+		}
+	}
+
+	return opts, errs
+}
+
+// reads the include_deleted query parameter, only callers holding users:write may see deleted users
+func parseIncludeDeleted(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	value := r.URL.Query().Get("include_deleted")
+	if value == "" {
+		return false, true
+	}
+	includeDeleted, err := strconv.ParseBool(value)
+	if err != nil {
+		utils.WriteValidationProblem(w, utils.ValidationErrors{{Field: "include_deleted", Message: "include_deleted must be true or false"}})
+		return false, false
+	}
+	if includeDeleted && !middleware.HasPermission(r.Context(), models.PermissionUsersWrite) {
+		utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "include_deleted requires the users:write permission")
+		return false, false
+	}
+	return includeDeleted, true
+}
+
+// reads the user ID route variable, answering 404 when it is not a UUID
+func parseUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	return parseRouteID(w, r, "id", utils.CodeUserNotFound, "User not found")
+}
+
+// reads a route variable holding an ID, answering 404 when it is not a UUID. Postgres accepts more
+// spellings of a UUID than we do, so the database must never see the raw variable.
+func parseRouteID(w http.ResponseWriter, r *http.Request, name, code, notFound string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)[name])
+	if err != nil {
+		utils.WriteProblem(w, http.StatusNotFound, code, notFound)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// answers 403 unless the caller holds everything the target account holds. users:write can be granted
+// through groups, it must not be enough to take over an admin, or anyone holding a permission the caller
+// lacks, by setting their password or email. Users always pass on their own account.
+func checkTargetPrivileges(w http.ResponseWriter, r *http.Request, targetIsAdmin bool, targetPermissions []string) bool {
+	identity, ok := middleware.IdentityFromContext(r.Context())
+	if ok && identity.IsAdmin {
+		return true
+	}
+	if targetIsAdmin {
+		utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Only admins may change an admin account")
+		return false
+	}
+	for _, permission := range targetPermissions {
+		if !ok || !identity.Permissions[permission] {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Only callers holding every permission of an account may change it")
+			return false
+		}
+	}
+	return true
+}
+
+// looks up the target's privileges before checkTargetPrivileges, answering 404 for unknown users
+func checkStoredTargetPrivileges(w http.ResponseWriter, r *http.Request, users store.UserStore, id uuid.UUID) bool {
+	isAdmin, permissions, err := users.Privileges(r.Context(), id)
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, http.StatusNotFound, utils.CodeUserNotFound, "User not found")
+		} else {
+			middleware.Logger(r.Context()).Error("Error querying user", "error", err)
+			utils.WriteInternalError(w, r)
+		}
+		return false
+	}
+	return checkTargetPrivileges(w, r, isAdmin, permissions)
+}
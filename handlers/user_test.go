@@ -2,9 +2,15 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"go-berry/config"
+	"go-berry/mailer"
+	"go-berry/middleware"
 	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -28,7 +34,7 @@ func TestGetAllUsers(t *testing.T) {
 	// Generate user data
 	userCount := 10
 	expectedUsers := make([]models.User, userCount)
-	rows := sqlmock.NewRows([]string{"id", "name", "email"})
+	rows := sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"})
 	for i := 1; i <= userCount; i++ {
 		user := models.User{
 			ID:    uuid.New(),
@@ -36,13 +42,13 @@ func TestGetAllUsers(t *testing.T) {
 			Email: faker.Email(),
 		}
 		expectedUsers[i-1] = user
-		rows.AddRow(user.ID, user.Name, user.Email)
+		rows.AddRow(user.ID, user.Name, user.Email, nil, nil, time.Now(), time.Now(), nil, true, nil, nil)
 	}
 
-	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users WHERE deleted_at IS NULL").
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(userCount))
 
-	mock.ExpectQuery("SELECT id, name, email FROM users LIMIT (.+) OFFSET (.+)").
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE deleted_at IS NULL ORDER BY created_at ASC, id ASC LIMIT (.+) OFFSET (.+)").
 		WithArgs(10, 0).
 		WillReturnRows(rows)
 
@@ -54,7 +60,7 @@ func TestGetAllUsers(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := GetAllUsers(db)
+	handler := GetAllUsers(store.NewPostgresUserStore(db))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
@@ -106,10 +112,16 @@ func TestGetUser(t *testing.T) {
 		IsActive:  true,
 	}
 
-	mock.ExpectQuery("SELECT id, name, email, created_at, updated_at, is_active FROM users WHERE id = \\$1").
+	mock.ExpectQuery("SELECT id, name, email, email_verified_at, username, created_at, updated_at, last_login, is_active, metadata, deleted_at FROM users WHERE id = \\$1 AND deleted_at IS NULL").
 		WithArgs(userID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "created_at", "updated_at", "is_active"}).
-			AddRow(expectedUser.ID, expectedUser.Name, expectedUser.Email, expectedUser.CreatedAt, expectedUser.UpdatedAt, expectedUser.IsActive))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(expectedUser.ID, expectedUser.Name, expectedUser.Email, nil, nil, expectedUser.CreatedAt, expectedUser.UpdatedAt, nil, expectedUser.IsActive, nil, nil))
+
+	groupID := uuid.New()
+	mock.ExpectQuery("SELECT g.id, g.name, g.description, g.created_at, g.updated_at, g.metadata FROM groups g JOIN user_groups ug").
+		WithArgs(userID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at", "metadata"}).
+			AddRow(groupID, "support", nil, time.Now(), time.Now(), nil))
 
 	// Create a simulated HTTP request
 	req, err := http.NewRequest("GET", "/users/"+userID.String(), nil)
@@ -121,7 +133,7 @@ func TestGetUser(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := GetUser(db)
+	handler := GetUser(store.NewPostgresUserStore(db))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
@@ -139,6 +151,9 @@ func TestGetUser(t *testing.T) {
 	assert.WithinDuration(t, expectedUser.UpdatedAt, actualUser.UpdatedAt, time.Second, "User updated_at timestamps should match")
 	assert.Equal(t, expectedUser.IsActive, actualUser.IsActive, "User is_active status should match")
 
+	assert.Len(t, actualUser.Groups, 1, "User groups should be filled in")
+	assert.Equal(t, groupID, actualUser.Groups[0].ID, "Group IDs should match")
+
 	// Ensure the password field is empty in the response
 	assert.Equal(t, "", actualUser.Password, "Password field should be empty")
 }
@@ -161,9 +176,16 @@ func TestCreateUser(t *testing.T) {
 
 	now := time.Now()
 
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)\\)").
+		WithArgs(userInput.Email).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO users").
-		WithArgs(sqlmock.AnyArg(), userInput.Name, userInput.Email, sqlmock.AnyArg(), now, now, true).
+		WithArgs(sqlmock.AnyArg(), userInput.Name, userInput.Email, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserCreated, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -178,7 +200,10 @@ func TestCreateUser(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := CreateUser(db)
+	tokens := newTestTokenManager()
+	mail := &recordingMailer{sent: make(chan mailer.Message, 1)}
+	mailConfig := config.MailConfig{EmailVerificationURL: "https://app.example.com/verify", EmailVerificationTTL: time.Hour}
+	handler := CreateUser(store.NewPostgresUserStore(db), tokens, mailer.NewQueue(mail), mailConfig)
 
 	handler.ServeHTTP(rr, req)
 
@@ -202,6 +227,15 @@ func TestCreateUser(t *testing.T) {
 	assert.True(t, responseUser.IsActive)
 	assert.WithinDuration(t, now, responseUser.CreatedAt, time.Second)
 	assert.WithinDuration(t, now, responseUser.UpdatedAt, time.Second)
+	assert.Nil(t, responseUser.EmailVerifiedAt, "The email should start out unverified")
+
+	select {
+	case message := <-mail.sent:
+		assert.Equal(t, userInput.Email, message.To)
+		assert.Contains(t, message.Body, "https://app.example.com/verify?token=", "The email should link to the verification page")
+	case <-time.After(time.Second):
+		t.Fatal("No verification email was sent")
+	}
 
 	// Ensure all expectations were met
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -223,6 +257,7 @@ func TestUpdateUser(t *testing.T) {
 		ID:        userID,
 		Name:      faker.Name(),
 		Email:     faker.Email(),
+		Password:  "StrongP@ssw0rd",
 		UpdatedAt: time.Now(),
 	}
 
@@ -231,9 +266,33 @@ func TestUpdateUser(t *testing.T) {
 	// 	return nil
 	// }
 
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}))
+	mock.ExpectQuery("SELECT id, name, email, email_verified_at, username, created_at, updated_at, last_login, is_active, metadata, deleted_at FROM users WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(userID, faker.Name(), "before@example.com", time.Now(), nil, time.Now(), time.Now(), nil, true, nil, nil))
+	mock.ExpectQuery("SELECT g.id, g.name, g.description, g.created_at, g.updated_at, g.metadata FROM groups g JOIN user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at", "metadata"}))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)\\)").
+		WithArgs(expectedUser.Email).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 	mock.ExpectBegin()
-	mock.ExpectExec("UPDATE users SET name = \\$1, email = \\$2, updated_at = \\$3 WHERE id = \\$4").
-		WithArgs(expectedUser.Name, expectedUser.Email, sqlmock.AnyArg(), userID).
+	mock.ExpectQuery("SELECT id, name, email, email_verified_at, username, created_at, updated_at, last_login, is_active, metadata, deleted_at FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(userID, faker.Name(), faker.Email(), time.Now(), nil, time.Now(), time.Now(), nil, true, nil, nil))
+	mock.ExpectQuery("UPDATE users SET name = \\$1, email_verified_at = CASE WHEN email = \\$2 THEN email_verified_at END, email = \\$3, updated_at = \\$4 WHERE id = \\$5 RETURNING").
+		WithArgs(expectedUser.Name, expectedUser.Email, expectedUser.Email, sqlmock.AnyArg(), userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(userID, expectedUser.Name, expectedUser.Email, nil, nil, time.Now(), expectedUser.UpdatedAt, nil, true, nil, nil))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserUpdated, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -252,7 +311,7 @@ func TestUpdateUser(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := UpdateUser(db)
+	handler := UpdateUser(store.NewPostgresUserStore(db))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
@@ -293,13 +352,27 @@ func TestDeleteUser(t *testing.T) {
 		Email: faker.Email(),
 	}
 
-	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT name, email FROM users WHERE id = \\$1").
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL").
 		WithArgs(userID).
-		WillReturnRows(sqlmock.NewRows([]string{"name", "email"}).AddRow(expectedUser.Name, expectedUser.Email))
-
-	mock.ExpectExec("DELETE FROM users WHERE id = \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, email, email_verified_at, username, created_at, updated_at, last_login, is_active, metadata, deleted_at FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
 		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(userID, expectedUser.Name, expectedUser.Email, nil, nil, time.Now(), time.Now(), nil, true, nil, nil))
+
+	mock.ExpectQuery("UPDATE users SET deleted_at = \\$1, updated_at = \\$2 WHERE id = \\$3 RETURNING").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(userID, expectedUser.Name, expectedUser.Email, nil, nil, time.Now(), time.Now(), nil, true, nil, time.Now()))
+	mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE user_id = \\$2 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserDeleted, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -313,7 +386,7 @@ func TestDeleteUser(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := DeleteUser(db)
+	handler := DeleteUser(store.NewPostgresUserStore(db))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
@@ -332,3 +405,332 @@ func TestDeleteUser(t *testing.T) {
 		t.Errorf("There were unfulfilled expectations: %v", err)
 	}
 }
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	existing := models.User{ID: uuid.New(), Name: "Existing User", Email: "taken@example.com"}
+	users.Create(context.Background(), &existing, store.AuditContext{})
+
+	body, _ := json.Marshal(models.User{Name: "Another User", Email: "taken@example.com", Password: "StrongP@ssw0rd"})
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	mail := &recordingMailer{sent: make(chan mailer.Message, 1)}
+	CreateUser(users, newTestTokenManager(), mailer.NewQueue(mail), config.MailConfig{}).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should reject an email that is already registered")
+	_, total, _ := users.List(context.Background(), store.ListOptions{Limit: 10})
+	assert.Equal(t, 1, total, "No user should have been stored")
+	assert.Empty(t, mail.sent, "No verification email should be sent")
+}
+
+func TestGetUserInvalidID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/not-a-uuid", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "not-a-uuid"})
+	rr := httptest.NewRecorder()
+
+	GetUser(store.NewMemoryUserStore()).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Should return 404 for an ID that is not a UUID")
+}
+
+func newPatchRequest(userID uuid.UUID, body string, identity *middleware.Identity) *http.Request {
+	req := httptest.NewRequest(http.MethodPatch, "/users/"+userID.String(), bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req = mux.SetURLVars(req, map[string]string{"id": userID.String()})
+	return req.WithContext(middleware.WithIdentity(req.Context(), identity))
+}
+
+func TestPatchUser(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	existing := models.User{
+		ID:       uuid.New(),
+		Name:     "Original Name",
+		Email:    "original@example.com",
+		IsActive: true,
+		Metadata: map[string]interface{}{"theme": "dark", "notifications": map[string]interface{}{"email": true, "sms": true}},
+	}
+	users.Create(context.Background(), &existing, store.AuditContext{})
+
+	body := `{"name":"Patched Name","username":"patched","metadata":{"theme":null,"notifications":{"sms":false}}}`
+	rr := httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(existing.ID, body, &middleware.Identity{UserID: existing.ID}))
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var actualUser models.User
+	if err := json.NewDecoder(rr.Body).Decode(&actualUser); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	assert.Equal(t, "Patched Name", actualUser.Name)
+	assert.Equal(t, "patched", actualUser.Username)
+	assert.Equal(t, existing.Email, actualUser.Email, "Fields missing from the patch should be kept")
+	assert.True(t, actualUser.IsActive, "Fields missing from the patch should be kept")
+	assert.Equal(t, map[string]interface{}{"notifications": map[string]interface{}{"email": true, "sms": false}}, actualUser.Metadata,
+		"Metadata should be merge patched")
+
+	stored, _ := users.Get(context.Background(), existing.ID, false)
+	assert.Equal(t, "Patched Name", stored.Name, "The patch should be stored")
+	assert.Equal(t, actualUser.Metadata, stored.Metadata, "The response should be the stored row")
+}
+
+func TestPatchUserIsActiveRequiresPermission(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	existing := models.User{ID: uuid.New(), Name: "Some User", Email: "some@example.com", IsActive: true}
+	users.Create(context.Background(), &existing, store.AuditContext{})
+
+	rr := httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(existing.ID, `{"is_active":false}`, &middleware.Identity{UserID: existing.ID}))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Users should not deactivate themselves")
+
+	rr = httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(existing.ID, `{"is_active":false}`, &middleware.Identity{UserID: uuid.New(), IsAdmin: true}))
+	assert.Equal(t, http.StatusOK, rr.Code, "Admins may deactivate users")
+
+	stored, _ := users.Get(context.Background(), existing.ID, false)
+	assert.False(t, stored.IsActive)
+}
+
+func TestUpdateUserKeepsEmailsUnique(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	existing := models.User{ID: uuid.New(), Name: "Original Name", Email: "original@example.com", CreatedAt: time.Now(), IsActive: true}
+	other := models.User{ID: uuid.New(), Name: "Other Name", Email: "other@example.com", CreatedAt: time.Now(), IsActive: true}
+	users.Create(context.Background(), &existing, store.AuditContext{})
+	users.Create(context.Background(), &other, store.AuditContext{})
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/users/"+existing.ID.String(), bytes.NewBufferString(body))
+		req = mux.SetURLVars(req, map[string]string{"id": existing.ID.String()})
+		rr := httptest.NewRecorder()
+		UpdateUser(users).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := put(`{"name":"Original Name","email":"OTHER@example.com","password":"StrongP@ssw0rd"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should not take another user's email")
+	assert.Contains(t, rr.Body.String(), `"field":"email"`)
+
+	rr = put(`{"name":"Renamed","email":"original@example.com","password":"StrongP@ssw0rd"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, "Keeping the own email is fine")
+}
+
+func TestPatchUserOfAdminNeedsAdmin(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	admin := models.User{ID: uuid.New(), Name: "Root", Email: "root@example.com", CreatedAt: time.Now(), IsActive: true, IsAdmin: true}
+	users.Create(context.Background(), &admin, store.AuditContext{})
+	writer := &middleware.Identity{UserID: uuid.New(), Permissions: map[string]bool{models.PermissionUsersWrite: true}}
+
+	rr := httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(admin.ID, `{"email":"mine@example.com"}`, writer))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "users:write alone must not change an admin's email")
+
+	rr = httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(admin.ID, `{"is_active":false}`, writer))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "users:write alone must not deactivate an admin")
+
+	rr = httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(admin.ID, `{"name":"Root User"}`, &middleware.Identity{UserID: admin.ID, IsAdmin: true}))
+	assert.Equal(t, http.StatusOK, rr.Code, "Admins may edit their own account")
+
+	rr = httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(admin.ID, `{"email":"root2@example.com"}`, &middleware.Identity{UserID: uuid.New(), IsAdmin: true}))
+	assert.Equal(t, http.StatusOK, rr.Code, "Admins may change another admin's email")
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/users/"+admin.ID.String(), nil)
+	req = mux.SetURLVars(req, map[string]string{"id": admin.ID.String()})
+	DeleteUser(users).ServeHTTP(rr, req.WithContext(middleware.WithIdentity(req.Context(), writer)))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "users:write alone must not delete an admin")
+}
+
+func TestPatchUserValidatesSuppliedFields(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	existing := models.User{ID: uuid.New(), Name: "Some User", Email: "some@example.com"}
+	other := models.User{ID: uuid.New(), Name: "Other User", Email: "other@example.com"}
+	users.Create(context.Background(), &existing, store.AuditContext{})
+	users.Create(context.Background(), &other, store.AuditContext{})
+
+	rr := httptest.NewRecorder()
+	body := `{"email":"other@example.com","password":"Secr3t!pass"}`
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(existing.ID, body, &middleware.Identity{UserID: existing.ID}))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	var problem utils.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	assert.Equal(t, []utils.FieldError{
+		{Field: "password", Message: "password cannot be changed with PATCH"},
+	}, problem.Errors, "Unknown fields are rejected before anything is looked up")
+
+	rr = httptest.NewRecorder()
+	PatchUser(users).ServeHTTP(rr, newPatchRequest(existing.ID, `{"email":"other@example.com"}`, &middleware.Identity{UserID: existing.ID}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should not take another user's email")
+
+	rr = httptest.NewRecorder()
+	req := newPatchRequest(existing.ID, `{"name":"Fine Name"}`, &middleware.Identity{UserID: existing.ID})
+	req.Header.Set("Content-Type", "text/plain")
+	PatchUser(users).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+
+	stored, _ := users.Get(context.Background(), existing.ID, false)
+	assert.Equal(t, "some@example.com", stored.Email, "Rejected patches should not be stored")
+}
+
+func TestRestoreUser(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	existing := models.User{ID: uuid.New(), Name: "Deleted User", Email: "deleted@example.com", IsActive: true}
+	users.Create(context.Background(), &existing, store.AuditContext{})
+	users.Delete(context.Background(), existing.ID, store.AuditContext{})
+
+	admin := &middleware.Identity{UserID: uuid.New(), IsAdmin: true}
+	req := httptest.NewRequest(http.MethodGet, "/users/"+existing.ID.String(), nil)
+	req = mux.SetURLVars(req, map[string]string{"id": existing.ID.String()})
+	rr := httptest.NewRecorder()
+	GetUser(users).ServeHTTP(rr, req.WithContext(middleware.WithIdentity(req.Context(), admin)))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Deleted users should be hidden by default")
+
+	req = httptest.NewRequest(http.MethodGet, "/users/"+existing.ID.String()+"?include_deleted=true", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": existing.ID.String()})
+	rr = httptest.NewRecorder()
+	GetUser(users).ServeHTTP(rr, req.WithContext(middleware.WithIdentity(req.Context(), &middleware.Identity{UserID: existing.ID})))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "include_deleted should need users:write")
+
+	rr = httptest.NewRecorder()
+	GetUser(users).ServeHTTP(rr, req.WithContext(middleware.WithIdentity(req.Context(), admin)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"deleted_at"`)
+
+	req = httptest.NewRequest(http.MethodPost, "/users/"+existing.ID.String()+"/restore", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": existing.ID.String()})
+	req = req.WithContext(middleware.WithIdentity(req.Context(), admin))
+	rr = httptest.NewRecorder()
+	RestoreUser(users).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+	assert.NotContains(t, rr.Body.String(), `"deleted_at"`)
+
+	rr = httptest.NewRecorder()
+	RestoreUser(users).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Users that are not deleted cannot be restored")
+
+	stored, err := users.Get(context.Background(), existing.ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, existing.Name, stored.Name)
+}
+
+func TestGetAllUsersFiltersAndSorts(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	start := time.Now()
+	for i, name := range []string{"Ada Lovelace", "Grace Hopper", "Adele Goldberg"} {
+		user := models.User{ID: uuid.New(), Name: name, Email: fmt.Sprintf("user%d@example.com", i), CreatedAt: start.Add(time.Duration(i) * time.Minute), IsActive: true}
+		users.Create(context.Background(), &user, store.AuditContext{})
+	}
+
+	rr := httptest.NewRecorder()
+	GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?q=ad&is_active=true&sort=-created_at", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	var response models.PaginatedResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	assert.Equal(t, 2, response.TotalUsers)
+	if assert.Len(t, response.Users, 2) {
+		assert.Equal(t, "Adele Goldberg", response.Users[0].Name, "Newest users should come first")
+		assert.Equal(t, "Ada Lovelace", response.Users[1].Name)
+	}
+}
+
+func TestGetAllUsersValidatesFilters(t *testing.T) {
+	rr := httptest.NewRecorder()
+	GetAllUsers(store.NewMemoryUserStore()).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?sort=-password&is_active=maybe&group=admins&created_from=yesterday", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	for _, field := range []string{"sort", "is_active", "group", "created_from"} {
+		assert.Contains(t, rr.Body.String(), `"field":"`+field+`"`)
+	}
+}
+
+func TestGetAllUsersBoundsPagination(t *testing.T) {
+	users := store.NewMemoryUserStore()
+
+	for _, query := range []string{"limit=1000000", "limit=9223372036854775807&cursor="} {
+		rr := httptest.NewRecorder()
+		GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?"+query, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+		assert.Contains(t, rr.Body.String(), `"limit":100`, "The limit should be capped for %s", query)
+	}
+
+	rr := httptest.NewRecorder()
+	GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?page=9223372036854775807&limit=10", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "A page whose offset overflows should be refused")
+	assert.Contains(t, rr.Body.String(), `"field":"page"`)
+}
+
+func TestGetAllUsersByCursor(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	start := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		user := models.User{ID: uuid.New(), Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i), CreatedAt: start.Add(time.Duration(i/2) * time.Minute)}
+		users.Create(context.Background(), &user, store.AuditContext{})
+	}
+
+	walk := func(query string) []string {
+		var seen []string
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			rr := httptest.NewRecorder()
+			GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?limit=2&cursor="+cursor+query, nil))
+			assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+			var response models.CursorPaginatedResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Error decoding the response: %v", err)
+			}
+			for _, user := range response.Users {
+				seen = append(seen, user.Name)
+			}
+			if response.NextCursor == "" {
+				return seen
+			}
+			cursor = response.NextCursor
+		}
+		t.Fatal("The walk never reached the last page")
+		return nil
+	}
+
+	names := walk("")
+	assert.Len(t, names, 5, "Every user should be listed exactly once, even with equal created_at")
+	assert.Contains(t, []string{"User 0", "User 1"}, names[0], "Oldest users should come first")
+	assert.ElementsMatch(t, names, walk("&sort=-created_at"))
+	assert.Equal(t, "User 4", walk("&sort=-created_at")[0], "Newest users should come first")
+
+	rr := httptest.NewRecorder()
+	GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?limit=10&cursor=&include_total=false", nil))
+	assert.NotContains(t, rr.Body.String(), "total_users", "The count can be skipped")
+	assert.NotContains(t, rr.Body.String(), "next_cursor", "The last page has no next cursor")
+
+	rr = httptest.NewRecorder()
+	GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users?cursor=not-a-cursor&sort=name", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should return status 400 Bad Request")
+	assert.Contains(t, rr.Body.String(), `"field":"cursor"`)
+	assert.Contains(t, rr.Body.String(), `"field":"sort"`)
+}
+
+func TestGetAllUsersGivesUpWithTheRequest(t *testing.T) {
+	users := store.NewMemoryUserStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx))
+	assert.Equal(t, utils.StatusClientClosedRequest, rr.Code, "A client that hung up should get 499")
+	assert.Contains(t, rr.Body.String(), `"code":"request_cancelled"`)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	rr = httptest.NewRecorder()
+	GetAllUsers(users).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "A request past its deadline should get 503")
+	assert.Contains(t, rr.Body.String(), `"code":"request_timeout"`)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-berry/config"
+	"go-berry/mailer"
+	"go-berry/middleware"
+	"go-berry/models"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+)
+
+// handles POST requests that redeem a verification token, marking the address it was sent to as verified
+func VerifyEmail(db *sql.DB, tokens *utils.TokenManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request models.VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		if strings.TrimSpace(request.Token) == "" {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "token is required")
+			return
+		}
+
+		claims, err := tokens.Parse(strings.TrimSpace(request.Token), utils.EmailVerificationToken)
+		if err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidToken, "Invalid or expired verification token")
+			return
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidToken, "Invalid or expired verification token")
+			return
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			middleware.Logger(ctx).Error("Error beginning transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		// Matching the email makes links sent to a previous address useless, verifying twice keeps the first date
+		var verifiedAt time.Time
+		var alreadyVerified bool
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT COALESCE(email_verified_at, $1), email_verified_at IS NOT NULL FROM users WHERE id = $2 AND email = $3 AND deleted_at IS NULL FOR UPDATE",
+			time.Now(), userID, claims.Email,
+		).Scan(&verifiedAt, &alreadyVerified)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidToken, "Invalid or expired verification token")
+			return
+		}
+		if err == nil && !alreadyVerified {
+			_, err = tx.ExecContext(ctx, "UPDATE users SET email_verified_at = $1 WHERE id = $2", verifiedAt, userID)
+			if err == nil {
+				err = recordAuditEvent(ctx, tx, r, models.AuditUserEmailVerified, userID, map[string]interface{}{
+					"email_verified_at": map[string]interface{}{"to": verifiedAt},
+				})
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.Logger(ctx).Error("Error verifying email", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			middleware.Logger(ctx).Error("Error committing transaction", "error", err)
+			utils.WriteInternalError(w, r)
+			return
+		}
+
+		response := map[string]string{
+			"message": "Email verified",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handles POST requests to send another verification link, answering the same whether or not the email
+// is registered, unverified or still cooling down
+func ResendVerificationEmail(db *sql.DB, tokens *utils.TokenManager, mail *mailer.Queue, mailConfig config.MailConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request models.ResendVerificationRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		email := strings.TrimSpace(request.Email)
+		if email == "" {
+			utils.WriteProblem(w, http.StatusBadRequest, utils.CodeInvalidRequest, "email is required")
+			return
+		}
+
+		// Failures are only logged, an error response would tell registered emails apart
+		if err := resendVerification(ctx, db, tokens, mail, mailConfig, email); err != nil {
+			middleware.Logger(ctx).Error("Error resending email verification", "error", err)
+		}
+
+		response := map[string]string{
+			"message": "If the email is registered and not yet verified, a verification link is on its way",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// claims the cooldown slot of an unverified account and mails it a new link. The account was
+// mailed when it was created, so the cooldown counts from then until the first resend.
+func resendVerification(ctx context.Context, db *sql.DB, tokens *utils.TokenManager, mail *mailer.Queue, mailConfig config.MailConfig, email string) error {
+	now := time.Now()
+	var userID uuid.UUID
+	var name string
+	err := db.QueryRowContext(
+		ctx,
+		"UPDATE users SET email_verification_sent_at = $1 WHERE lower(email) = lower($2) AND is_active AND deleted_at IS NULL AND email_verified_at IS NULL AND COALESCE(email_verification_sent_at, created_at) <= $3 RETURNING id, name",
+		now, email, now.Add(-mailConfig.EmailVerificationCooldown),
+	).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return sendEmailVerification(ctx, tokens, mail, mailConfig, userID, name, email)
+}
+
+// signs a verification link for the address and mails it in the background, so the response time does not depend on the relay
+func sendEmailVerification(ctx context.Context, tokens *utils.TokenManager, mail *mailer.Queue, mailConfig config.MailConfig, userID uuid.UUID, name, email string) error {
+	token, err := tokens.IssueEmailVerificationToken(userID, email, mailConfig.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	message := mailer.Message{
+		To:      email,
+		Subject: "Verify your GoBerry email address",
+		Body:    emailVerificationBody(name, token, mailConfig),
+	}
+	logger := middleware.Logger(ctx)
+	mail.Send(message, func(err error) {
+		logger.Error("Error mailing email verification", "recipient_id", userID, "error", err)
+	})
+	return nil
+}
+
+func emailVerificationBody(name, token string, mailConfig config.MailConfig) string {
+	instructions := "Use this token to verify your email address: " + token
+	if link, ok := tokenLink(mailConfig.EmailVerificationURL, token); ok {
+		instructions = "Follow this link to verify your email address: " + link
+	}
+
+	return fmt.Sprintf(
+		"Hello %s,\n\nPlease confirm that this address belongs to your GoBerry account.\n%s\n\nThe link expires in %s. If you did not create an account, you can ignore this email.\n",
+		name, instructions, mailConfig.EmailVerificationTTL,
+	)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-berry/config"
+	"go-berry/mailer"
+	"go-berry/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	tokens := newTestTokenManager()
+	token, _ := tokens.IssueEmailVerificationToken(userID, "berry@example.com", time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COALESCE\\(email_verified_at, \\$1\\), email_verified_at IS NOT NULL FROM users WHERE id = \\$2 AND email = \\$3 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(sqlmock.AnyArg(), userID, "berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"email_verified_at", "verified"}).AddRow(time.Now(), false))
+	mock.ExpectExec("UPDATE users SET email_verified_at = \\$1 WHERE id = \\$2").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserEmailVerified, userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// The user has since moved to another address
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COALESCE\\(email_verified_at, \\$1\\), email_verified_at IS NOT NULL FROM users WHERE id = \\$2 AND email = \\$3 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(sqlmock.AnyArg(), userID, "berry@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"email_verified_at", "verified"}))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(models.VerifyEmailRequest{Token: token})
+	handler := VerifyEmail(db, tokens)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/verify-email", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/verify-email", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Links sent to a previous address should not verify the new one")
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_token"`)
+
+	// Tokens of another kind are refused before the database is touched
+	accessToken, _ := tokens.IssueAccessToken(userID, uuid.New())
+	body, _ = json.Marshal(models.VerifyEmailRequest{Token: accessToken})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/verify-email", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Access tokens should not verify an email")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	// Create a mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	userID := uuid.New()
+	tokens := newTestTokenManager()
+	mail := &recordingMailer{sent: make(chan mailer.Message, 1)}
+	mailConfig := config.MailConfig{EmailVerificationTTL: time.Hour, EmailVerificationCooldown: 5 * time.Minute}
+	handler := ResendVerificationEmail(db, tokens, mailer.NewQueue(mail), mailConfig)
+
+	mock.ExpectQuery("UPDATE users SET email_verification_sent_at = \\$1 WHERE lower\\(email\\) = lower\\(\\$2\\) AND is_active AND deleted_at IS NULL AND email_verified_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), "berry@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(userID, "Berry"))
+	// Asking again right away is still within the cooldown
+	mock.ExpectQuery("UPDATE users SET email_verification_sent_at = \\$1 WHERE lower\\(email\\) = lower\\(\\$2\\) AND is_active AND deleted_at IS NULL AND email_verified_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), "berry@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/verify-email/resend", bytes.NewBufferString(`{"email":"berry@example.com"}`)))
+	first := rr
+
+	select {
+	case message := <-mail.sent:
+		assert.Equal(t, "berry@example.com", message.To)
+		assert.Contains(t, message.Body, "Use this token to verify your email address: ", "Without a page the token is sent as is")
+	case <-time.After(time.Second):
+		t.Fatal("No verification email was sent")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/verify-email/resend", bytes.NewBufferString(`{"email":"berry@example.com"}`)))
+
+	assert.Equal(t, http.StatusAccepted, first.Code)
+	assert.Equal(t, first.Body.String(), rr.Body.String(), "A cooling down account should get the same answer")
+	assert.Empty(t, mail.sent, "Nothing should be sent during the cooldown")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
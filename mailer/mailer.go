@@ -0,0 +1,13 @@
+package mailer
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email, handlers depend on it instead of on a particular transport
+type Mailer interface {
+	Send(message Message) error
+}
@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer(&buf)
+
+	err := mailer.Send(Message{To: "ada@example.com", Subject: "Hello", Body: "First line\nSecond line"})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "To: ada@example.com\nSubject: Hello\n\nFirst line\nSecond line")
+}
+
+func TestSMTPMailerFormat(t *testing.T) {
+	mailer, err := NewSMTPMailer("smtp.example.com", 587, "", "", "GoBerry <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("Error creating the mailer: %v", err)
+	}
+	assert.Equal(t, "smtp.example.com:587", mailer.addr)
+	assert.Nil(t, mailer.auth, "No credentials means no AUTH")
+
+	raw := string(mailer.format(Message{To: "ada@example.com", Subject: "Hello", Body: "First line\nSecond line"}, time.Unix(0, 0).UTC()))
+	headers, body, found := strings.Cut(raw, "\r\n\r\n")
+	assert.True(t, found, "Headers and body should be separated by a blank line")
+	assert.Contains(t, headers, "From: GoBerry <no-reply@example.com>\r\nTo: ada@example.com\r\nSubject: Hello\r\n")
+	assert.Equal(t, "First line\r\nSecond line", body)
+}
+
+func TestSMTPMailerEnvelopeSender(t *testing.T) {
+	mailer, err := NewSMTPMailer("smtp.example.com", 587, "", "", "GoBerry <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("Error creating the mailer: %v", err)
+	}
+
+	var sender string
+	var recipients []string
+	var raw []byte
+	mailer.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		sender, recipients, raw = from, to, msg
+		return nil
+	}
+
+	assert.NoError(t, mailer.Send(Message{To: "ada@example.com", Subject: "Hello", Body: "Hi"}))
+	assert.Equal(t, "no-reply@example.com", sender, "MAIL FROM takes the bare address")
+	assert.Equal(t, []string{"ada@example.com"}, recipients)
+	assert.Contains(t, string(raw), "From: GoBerry <no-reply@example.com>\r\n", "The header keeps the display name")
+
+	_, err = NewSMTPMailer("smtp.example.com", 587, "", "", "GoBerry")
+	assert.Error(t, err, "A sender without an address should be refused")
+}
+
+type slowMailer struct {
+	release chan struct{}
+	sent    []Message
+}
+
+func (m *slowMailer) Send(message Message) error {
+	<-m.release
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+func TestQueueWaitsForMessagesInFlight(t *testing.T) {
+	mailer := &slowMailer{release: make(chan struct{})}
+	queue := NewQueue(mailer)
+	queue.Send(Message{To: "ada@example.com"}, func(err error) { t.Errorf("Unexpected delivery error: %v", err) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, queue.Wait(ctx), context.DeadlineExceeded, "Waiting should give up with the context")
+
+	close(mailer.release)
+	assert.NoError(t, queue.Wait(context.Background()))
+	assert.Len(t, mailer.sent, 1, "The message should have been sent once Wait returns")
+}
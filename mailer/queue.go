@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Queue sends messages in the background, so responses do not wait on the relay, and keeps count of
+// the ones in flight so shutdown can wait for them
+type Queue struct {
+	mailer  Mailer
+	pending sync.WaitGroup
+}
+
+func NewQueue(mailer Mailer) *Queue {
+	return &Queue{mailer: mailer}
+}
+
+// hands the message over and returns at once, onError hears about a failed delivery
+func (q *Queue) Send(message Message, onError func(error)) {
+	q.pending.Add(1)
+	go func() {
+		defer q.pending.Done()
+		if err := q.mailer.Send(message); err != nil {
+			onError(err)
+		}
+	}()
+}
+
+// blocks until every message handed over has been sent or has failed, or until the context is done
+func (q *Queue) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
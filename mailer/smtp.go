@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer delivers email through an SMTP relay, authenticating when a username is set
+type SMTPMailer struct {
+	addr string
+	// from is the From header, display name included, sender is the bare address given in MAIL FROM
+	from   string
+	sender string
+	auth   smtp.Auth
+	// sendMail is smtp.SendMail, tests swap it to see what reaches the relay
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// from may carry a display name, as in "GoBerry <no-reply@example.com>"
+func NewSMTPMailer(host string, port int, username, password, from string) (*SMTPMailer, error) {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+
+	mailer := &SMTPMailer{
+		addr:     net.JoinHostPort(host, fmt.Sprint(port)),
+		from:     from,
+		sender:   address.Address,
+		sendMail: smtp.SendMail,
+	}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer, nil
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	return m.sendMail(m.addr, m.auth, m.sender, []string{message.To}, m.format(message, time.Now()))
+}
+
+// builds an RFC 5322 message with CRLF line endings
+func (m *SMTPMailer) format(message Message, date time.Time) []byte {
+	headers := []string{
+		"From: " + headerValue(m.from),
+		"To: " + headerValue(message.To),
+		"Subject: " + headerValue(message.Subject),
+		"Date: " + date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	body := strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n")
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body)
+}
+
+// drops line breaks so a value cannot smuggle in extra headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
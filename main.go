@@ -1,30 +1,165 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"go-berry/config"
+	"go-berry/mailer"
 	"go-berry/middleware"
+	"go-berry/migrations"
 	"go-berry/routes"
+	"go-berry/store"
+	"go-berry/utils"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 )
 
 func main () {
+	cfg, err := config.Read()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		fmt.Print(cfg.Dump())
+		return
+	}
+
+	// migrations only need the database, serving needs everything
+	migrate := len(os.Args) > 1 && os.Args[1] == "migrate"
+	validate := cfg.Validate
+	if migrate {
+		validate = cfg.ValidateDatabase
+	}
+	if err := validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// log as JSON, lines written through the log package included
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
 	// connnect to database
-	db, err := config.ConnectDatabase()
+	db, err := config.ConnectDatabase(cfg.Database)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	if migrate {
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	log.Printf("effective configuration:\n%s", cfg.Dump())
+
+	// bring the schema up to date, replicas booting together wait on the migration lock
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		log.Fatal(err)
+	}
+
+	utils.SetPasswordHashCost(cfg.Password.BcryptCost)
+	utils.SetPasswordPolicy(utils.PasswordPolicy{
+		MinLength:        cfg.Password.MinLength,
+		MaxLength:        cfg.Password.MaxLength,
+		RequireUppercase: cfg.Password.RequireUppercase,
+		RequireLowercase: cfg.Password.RequireLowercase,
+		RequireNumber:    cfg.Password.RequireNumber,
+		RequireSpecial:   cfg.Password.RequireSpecial,
+	})
+	tokens := utils.NewTokenManager([]byte(cfg.Auth.TokenSecret), cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+
+	transport, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mail := mailer.NewQueue(transport)
+
+	stopPurge := make(chan struct{})
+	go store.RunPurge(store.NewPostgresUserStore(db), cfg.Retention.DeletedUserRetention, cfg.Retention.PurgeInterval, stopPurge)
+
 	// initialize routes
 	r := mux.NewRouter()
-	routes.InitializeRoutes(r, db)
+	routes.InitializeRoutes(r, db, migrator, tokens, cfg, mail)
 
 	// start server
-	log.Fatal(http.ListenAndServe(":8080", middleware.JsonContentMiddleware(r)))
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           middleware.RequestLogger(logger)(middleware.JsonContentMiddleware(r)),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+	err = serve(server, cfg.Server, mail)
+
+	// the pool outlives the drain so in-flight requests can finish their queries
+	close(stopPurge)
+	db.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Server stopped")
 }
 
+// serves until SIGTERM or SIGINT, then stops accepting connections and gives in-flight requests, and
+// the emails they queued, up to the shutdown timeout before cutting them off
+func serve(server *http.Server, cfg config.ServerConfig, mail *mailer.Queue) error {
+	errs := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			errs <- server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, draining connections for up to %s", sig, cfg.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("draining connections: %w", err)
+	}
+	if err := mail.Wait(ctx); err != nil {
+		return fmt.Errorf("sending queued emails: %w", err)
+	}
+	return nil
+}
+
+
+// relays through SMTP when a host is configured, otherwise writes messages to MAIL_LOG_FILE or stdout
+func newMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+	if cfg.SMTPHost != "" {
+		return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, string(cfg.SMTPPassword), cfg.From)
+	}
+	if cfg.LogFile != "" {
+		return mailer.NewFileMailer(cfg.LogFile)
+	}
+	return mailer.NewLogMailer(os.Stdout), nil
+}
@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-berry/store"
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Access is the level of authentication a route requires
+type Access int
+
+const (
+	Public Access = iota
+	Authenticated
+	Admin
+)
+
+// Identity describes the caller behind a verified access token
+type Identity struct {
+	UserID             uuid.UUID
+	SessionID          uuid.UUID
+	IsAdmin            bool
+	Permissions        map[string]bool
+	MustChangePassword bool
+}
+
+// admins hold every permission, everyone else gets the union granted through their groups
+func (i *Identity) HasPermission(permission string) bool {
+	return i.IsAdmin || i.Permissions[permission]
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// returns the authenticated caller, if the request carried a valid token
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// reports whether the authenticated caller holds a permission, for handlers that check it themselves
+func HasPermission(ctx context.Context, permission string) bool {
+	identity, ok := IdentityFromContext(ctx)
+	return ok && identity.HasPermission(permission)
+}
+
+// Auth validates bearer tokens and enforces the access level declared by each route
+type Auth struct {
+	tokens *utils.TokenManager
+	db     *sql.DB
+}
+
+func NewAuth(tokens *utils.TokenManager, db *sql.DB) *Auth {
+	return &Auth{tokens: tokens, db: db}
+}
+
+// wraps a handler so it only runs for callers with the given access level
+func (a *Auth) Require(access Access, next http.Handler) http.Handler {
+	return a.require(access, false, next)
+}
+
+// callers whose password was reset by an admin only get through when allowPasswordChange is set
+func (a *Auth) require(access Access, allowPasswordChange bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		switch {
+		case access >= Authenticated && identity == nil:
+			unauthorized(w)
+			return
+		case identity != nil && identity.MustChangePassword && !allowPasswordChange:
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodePasswordChangeRequired, "The password must be changed before anything else")
+			return
+		case access == Admin && !identity.IsAdmin:
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Forbidden")
+			return
+		}
+
+		if identity != nil {
+			logUser(r.Context(), identity.UserID)
+			r = r.WithContext(WithIdentity(r.Context(), identity))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wraps a handler so it only runs for callers holding the given permission
+func (a *Auth) RequirePermission(permission string, next http.Handler) http.Handler {
+	return a.Require(Authenticated, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !HasPermission(r.Context(), permission) {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// wraps a handler so only the user named by the route variable, or an admin, may call it
+func (a *Auth) RequireSelfOrAdmin(param string, next http.Handler) http.Handler {
+	return a.Require(Authenticated, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := IdentityFromContext(r.Context())
+		if !identity.IsAdmin && !isSelf(r, param, identity) {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// wraps a handler so only the user named by the route variable, or a holder of the permission, may call it
+func (a *Auth) RequireSelfOrPermission(param, permission string, next http.Handler) http.Handler {
+	return a.Require(Authenticated, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := IdentityFromContext(r.Context())
+		if !identity.HasPermission(permission) && !isSelf(r, param, identity) {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// wraps a handler so only the user named by the route variable may call it, even while they still have to change their password
+func (a *Auth) RequireSelfAllowingPasswordChange(param string, next http.Handler) http.Handler {
+	return a.require(Authenticated, true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := IdentityFromContext(r.Context())
+		if !isSelf(r, param, identity) {
+			utils.WriteProblem(w, http.StatusForbidden, utils.CodeForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func isSelf(r *http.Request, param string, identity *Identity) bool {
+	return strings.EqualFold(mux.Vars(r)[param], identity.UserID.String())
+}
+
+// resolves the bearer token into an identity, answering 401 when one is present but invalid
+func (a *Auth) authenticate(w http.ResponseWriter, r *http.Request) (*Identity, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, true
+	}
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		unauthorized(w)
+		return nil, false
+	}
+
+	claims, err := a.tokens.Parse(token, utils.AccessToken)
+	if err != nil {
+		unauthorized(w)
+		return nil, false
+	}
+
+	identity := &Identity{}
+	identity.UserID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		unauthorized(w)
+		return nil, false
+	}
+	identity.SessionID, err = uuid.Parse(claims.SessionID)
+	if err != nil {
+		unauthorized(w)
+		return nil, false
+	}
+
+	// The session lookup makes revocation immediate and keeps the admin flag current
+	var isActive, isAdmin sql.NullBool
+	err = a.db.QueryRowContext(
+		r.Context(),
+		"SELECT u.is_active, u.is_admin, u.must_change_password FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > $3",
+		identity.SessionID, identity.UserID, time.Now(),
+	).Scan(&isActive, &isAdmin, &identity.MustChangePassword)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			Logger(r.Context()).Error("Error querying session", "error", err)
+			utils.WriteInternalError(w, r)
+			return nil, false
+		}
+		unauthorized(w)
+		return nil, false
+	}
+	if !isActive.Bool {
+		unauthorized(w)
+		return nil, false
+	}
+
+	identity.IsAdmin = isAdmin.Bool
+	if !identity.IsAdmin {
+		permissions, err := store.EffectivePermissions(r.Context(), a.db, identity.UserID)
+		if err != nil {
+			Logger(r.Context()).Error("Error querying permissions", "error", err)
+			utils.WriteInternalError(w, r)
+			return nil, false
+		}
+		identity.Permissions = make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			identity.Permissions[permission] = true
+		}
+	}
+
+	return identity, true
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="go-berry"`)
+	utils.WriteProblem(w, http.StatusUnauthorized, utils.CodeUnauthorized, "Unauthorized")
+}
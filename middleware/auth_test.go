@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-berry/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuth(t *testing.T) (*Auth, *utils.TokenManager, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	tokens := utils.NewTokenManager([]byte("0123456789abcdef0123456789abcdef"), time.Minute, time.Hour)
+	return NewAuth(tokens, db), tokens, mock
+}
+
+func expectSession(mock sqlmock.Sqlmock, userID, sessionID uuid.UUID, isAdmin bool, permissions ...string) {
+	expectSessionRow(mock, userID, sessionID, isAdmin, false)
+
+	if !isAdmin {
+		rows := sqlmock.NewRows([]string{"permission"})
+		for _, permission := range permissions {
+			rows.AddRow(permission)
+		}
+		mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+			WithArgs(userID.String()).
+			WillReturnRows(rows)
+	}
+}
+
+func expectSessionRow(mock sqlmock.Sqlmock, userID, sessionID uuid.UUID, isAdmin, mustChangePassword bool) {
+	mock.ExpectQuery("SELECT u.is_active, u.is_admin, u.must_change_password FROM sessions s JOIN users u").
+		WithArgs(sessionID, userID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"is_active", "is_admin", "must_change_password"}).AddRow(true, isAdmin, mustChangePassword))
+}
+
+func bearerRequest(t *testing.T, tokens *utils.TokenManager, userID, sessionID uuid.UUID) *http.Request {
+	token, err := tokens.IssueAccessToken(userID, sessionID)
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/users/"+userID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRequireAuthenticatedWithoutToken(t *testing.T) {
+	auth, _, _ := newTestAuth(t)
+
+	rr := httptest.NewRecorder()
+	auth.Require(Authenticated, okHandler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/groups", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Should return status 401 Unauthorized")
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"code":"unauthorized"`)
+}
+
+func TestRequirePublicAllowsAnonymous(t *testing.T) {
+	auth, _, _ := newTestAuth(t)
+
+	rr := httptest.NewRecorder()
+	auth.Require(Public, okHandler).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/users", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+}
+
+func TestRequireRejectsInvalidToken(t *testing.T) {
+	auth, _, _ := newTestAuth(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+
+	rr := httptest.NewRecorder()
+	auth.Require(Public, okHandler).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "A bad token should be rejected even on public routes")
+}
+
+func TestRequirePutsIdentityInContext(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	expectSession(mock, userID, sessionID, false)
+
+	var identity *Identity
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromContext(r.Context())
+	})
+
+	rr := httptest.NewRecorder()
+	auth.Require(Authenticated, handler).ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Should return status 200 OK")
+	if assert.NotNil(t, identity, "Identity should be available to the handler") {
+		assert.Equal(t, userID, identity.UserID)
+		assert.Equal(t, sessionID, identity.SessionID)
+		assert.False(t, identity.IsAdmin)
+	}
+}
+
+func TestRequireRevokedSession(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	mock.ExpectQuery("SELECT u.is_active, u.is_admin, u.must_change_password FROM sessions s JOIN users u").
+		WithArgs(sessionID, userID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"is_active", "is_admin"}))
+
+	rr := httptest.NewRecorder()
+	auth.Require(Authenticated, okHandler).ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Tokens of revoked sessions should be rejected")
+}
+
+func TestRequireAdmin(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	expectSession(mock, userID, sessionID, false)
+
+	rr := httptest.NewRecorder()
+	auth.Require(Admin, okHandler).ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Non-admins should be forbidden")
+}
+
+func TestRequireSelfOrAdmin(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	otherID := uuid.New()
+
+	router := mux.NewRouter()
+	router.Handle("/users/{id}", auth.RequireSelfOrAdmin("id", okHandler))
+
+	// Own record
+	expectSession(mock, userID, sessionID, false)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+	assert.Equal(t, http.StatusOK, rr.Code, "Users should reach their own record")
+
+	// Someone else's record
+	expectSession(mock, userID, sessionID, false)
+	req := bearerRequest(t, tokens, userID, sessionID)
+	req.URL.Path = "/users/" + otherID.String()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Users should not reach other records")
+
+	// Admins may reach any record
+	expectSession(mock, userID, sessionID, true)
+	req = bearerRequest(t, tokens, userID, sessionID)
+	req.URL.Path = "/users/" + otherID.String()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Admins should reach any record")
+}
+
+func TestRequirePermission(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+
+	expectSession(mock, userID, sessionID, false, "groups:admin")
+	rr := httptest.NewRecorder()
+	auth.RequirePermission("groups:admin", okHandler).ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+	assert.Equal(t, http.StatusOK, rr.Code, "Permissions granted through groups should be honoured")
+
+	expectSession(mock, userID, sessionID, false, "groups:admin")
+	rr = httptest.NewRecorder()
+	auth.RequirePermission("roles:admin", okHandler).ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Missing permissions should be forbidden")
+
+	expectSession(mock, userID, sessionID, true)
+	rr = httptest.NewRecorder()
+	auth.RequirePermission("roles:admin", okHandler).ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+	assert.Equal(t, http.StatusOK, rr.Code, "Admins should hold every permission")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRequireSelfOrPermission(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	otherID := uuid.New()
+
+	router := mux.NewRouter()
+	router.Handle("/users/{id}", auth.RequireSelfOrPermission("id", "users:read", okHandler))
+
+	expectSession(mock, userID, sessionID, false, "users:read")
+	req := bearerRequest(t, tokens, userID, sessionID)
+	req.URL.Path = "/users/" + otherID.String()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Holders of the permission should reach other records")
+
+	expectSession(mock, userID, sessionID, false)
+	req = bearerRequest(t, tokens, userID, sessionID)
+	req.URL.Path = "/users/" + otherID.String()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Users without the permission should not reach other records")
+}
+
+func TestRequirePendingPasswordChange(t *testing.T) {
+	auth, tokens, mock := newTestAuth(t)
+	userID, sessionID := uuid.New(), uuid.New()
+
+	router := mux.NewRouter()
+	router.Handle("/users/{id}", auth.Require(Authenticated, okHandler))
+	router.Handle("/users/{id}/password", auth.RequireSelfAllowingPasswordChange("id", okHandler))
+
+	expectSessionRow(mock, userID, sessionID, true, true)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, bearerRequest(t, tokens, userID, sessionID))
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Nothing else is reachable until the password is changed")
+	assert.Contains(t, rr.Body.String(), `"code":"password_change_required"`)
+
+	expectSessionRow(mock, userID, sessionID, true, true)
+	req := bearerRequest(t, tokens, userID, sessionID)
+	req.URL.Path = "/users/" + userID.String() + "/password"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "The password change itself should be reachable")
+
+	expectSessionRow(mock, userID, sessionID, true, false)
+	req = bearerRequest(t, tokens, userID, sessionID)
+	req.URL.Path = "/users/" + uuid.NewString() + "/password"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Not even admins change someone else's password there")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
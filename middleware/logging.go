@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader carries the id tying a request to its log lines, callers may supply their own
+const RequestIDHeader = "X-Request-ID"
+
+// longer or odd looking ids from callers are replaced, they end up verbatim in every log line
+const maxRequestIDLength = 128
+
+type requestLogKey struct{}
+
+// what the request log line needs from further down the chain, filled in as the request is routed and authenticated
+type requestLog struct {
+	id     string
+	logger *slog.Logger
+	route  string
+	userID uuid.UUID
+}
+
+// RequestLogger tags every request with an id, hands handlers a logger carrying it and logs one
+// line per request with the method, route template, status, latency, bytes written and caller.
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			entry := &requestLog{id: id, logger: logger.With("request_id", id)}
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry)))
+
+			attrs := []any{
+				"request_id", id,
+				"method", r.Method,
+				"route", entry.route,
+				"status", recorder.status,
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"bytes", recorder.bytes,
+			}
+			if entry.userID != uuid.Nil {
+				attrs = append(attrs, "user_id", entry.userID)
+			}
+			level := slog.LevelInfo
+			if recorder.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.Log(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// RecordRoute notes the matched route template for the request log line, it has to run as router middleware
+func RecordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				entry.route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Logger returns the logger of the request behind the context, falling back to the default one outside requests
+func Logger(ctx context.Context) *slog.Logger {
+	if entry, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return entry.logger
+	}
+	return slog.Default()
+}
+
+// returns the id RequestLogger assigned to the request behind the context
+func RequestIDFromContext(ctx context.Context) string {
+	if entry, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return entry.id
+	}
+	return ""
+}
+
+// attaches the authenticated caller to the request log line and to everything handlers log afterwards
+func logUser(ctx context.Context, userID uuid.UUID) {
+	if entry, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		entry.userID = userID
+		entry.logger = entry.logger.With("user_id", userID)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// remembers the status and size of the response for the request log line
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
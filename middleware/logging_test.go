@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLoggerLogsEachRequest(t *testing.T) {
+	var logs bytes.Buffer
+	userID := uuid.New()
+
+	router := mux.NewRouter()
+	router.Use(RecordRoute)
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logUser(r.Context(), userID)
+		Logger(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}).Methods("POST")
+	handler := RequestLogger(slog.New(slog.NewJSONHandler(&logs, nil)))(router)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String(), nil)
+	req.Header.Set(RequestIDHeader, "edge-1234")
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, "edge-1234", rr.Header().Get(RequestIDHeader), "A well formed id from the caller should be kept")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	var handlerLine, requestLine map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &handlerLine))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &requestLine))
+
+	assert.Equal(t, "edge-1234", handlerLine["request_id"], "Handler logs should carry the request id")
+	assert.Equal(t, userID.String(), handlerLine["user_id"], "Handler logs should carry the caller")
+
+	assert.Equal(t, "request", requestLine["msg"])
+	assert.Equal(t, "edge-1234", requestLine["request_id"])
+	assert.Equal(t, "POST", requestLine["method"])
+	assert.Equal(t, "/users/{id}", requestLine["route"], "The route template should be logged, not the path")
+	assert.Equal(t, float64(http.StatusCreated), requestLine["status"])
+	assert.Equal(t, float64(len(`{"ok":true}`)), requestLine["bytes"])
+	assert.Equal(t, userID.String(), requestLine["user_id"])
+	assert.Contains(t, requestLine, "latency_ms")
+}
+
+func TestRequestLoggerReplacesInvalidIDs(t *testing.T) {
+	handler := RequestLogger(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(RequestIDFromContext(r.Context())))
+	}))
+
+	for _, id := range []string{"", "has spaces", "line\nbreak", strings.Repeat("a", maxRequestIDLength+1)} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		handler.ServeHTTP(rr, req)
+
+		generated := rr.Header().Get(RequestIDHeader)
+		_, err := uuid.Parse(generated)
+		assert.NoError(t, err, "A fresh id should replace %q", id)
+		assert.Equal(t, generated, rr.Body.String(), "Handlers should see the id sent back")
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-berry/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// KeyFunc names the client a request counts against
+type KeyFunc func(r *http.Request) string
+
+// counts requests against the address of the peer
+func KeyByIP(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+// counts requests against the user behind a valid access token, anonymous requests against their address
+func KeyByUser(tokens *utils.TokenManager) KeyFunc {
+	return func(r *http.Request) string {
+		scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			if claims, err := tokens.Parse(token, utils.AccessToken); err == nil {
+				return "user:" + claims.Subject
+			}
+		}
+		return KeyByIP(r)
+	}
+}
+
+// RatePolicy allows Limit requests per Period for every client Key picks, in bursts of up to Limit
+type RatePolicy struct {
+	Limit  int
+	Period time.Duration
+	Key    KeyFunc
+}
+
+// RateLimiter applies the policy registered for the matched route, or the default one, to every request
+type RateLimiter struct {
+	store    RateLimitStore
+	fallback RatePolicy
+	routes   map[string]RatePolicy
+}
+
+// a zero Limit in the default policy leaves routes without their own policy unlimited
+func NewRateLimiter(store RateLimitStore, fallback RatePolicy) *RateLimiter {
+	return &RateLimiter{store: store, fallback: fallback, routes: map[string]RatePolicy{}}
+}
+
+// sets the policy for requests with the given method to a route path template, such as "/users/{id}"
+func (l *RateLimiter) Route(method, pathTemplate string, policy RatePolicy) {
+	l.routes[routePattern(method, pathTemplate)] = policy
+}
+
+func routePattern(method, pathTemplate string) string {
+	return strings.ToUpper(method) + " " + pathTemplate
+}
+
+// mux middleware, the route has to be matched already so its template can pick the policy
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := "default"
+		policy := l.fallback
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				if routePolicy, ok := l.routes[routePattern(r.Method, template)]; ok {
+					pattern = routePattern(r.Method, template)
+					policy = routePolicy
+				}
+			}
+		}
+		if policy.Limit <= 0 || policy.Key == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Buckets are per policy, so a busy route does not use up the allowance of the others
+		result, err := l.store.Take(pattern+"|"+policy.Key(r), policy.Limit, policy.Period)
+		if err != nil {
+			// Failing open keeps the API up when a shared store is unreachable
+			Logger(r.Context()).Error("Error checking rate limit", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, ceilSeconds(policy.Period)))
+		header.Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			utils.WriteProblem(w, http.StatusTooManyRequests, utils.CodeRateLimited, "Too many requests, try again later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
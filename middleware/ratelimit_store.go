@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitResult is the state of a bucket after a request tried to take a token from it
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next token, zero when the request was allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets. The in-memory store limits each replica on its own,
+// a shared backend implementing the same interface lets replicas enforce one limit together.
+type RateLimitStore interface {
+	// Take refills the bucket under key at limit tokens per period, up to limit, then removes one token if it can
+	Take(key string, limit int, period time.Duration) (RateLimitResult, error)
+}
+
+// buckets beyond this count trigger a sweep of the ones that refilled completely
+const rateLimitSweepSize = 10000
+
+// MemoryRateLimitStore keeps token buckets in a map
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit int, period time.Duration) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	capacity := float64(limit)
+	perSecond := capacity / period.Seconds()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	var result RateLimitResult
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - bucket.tokens) / perSecond)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = seconds((capacity - bucket.tokens) / perSecond)
+	bucket.full = now.Add(result.Reset)
+
+	if len(s.buckets) > rateLimitSweepSize {
+		for k, b := range s.buckets {
+			if !b.full.After(now) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
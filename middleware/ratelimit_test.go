@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-berry/utils"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimitStoreRefills(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return start }
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Take("ip:192.0.2.1", 3, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed, "The bucket starts full")
+		assert.Equal(t, 2-i, result.Remaining)
+	}
+
+	result, _ := store.Take("ip:192.0.2.1", 3, time.Minute)
+	assert.False(t, result.Allowed, "An empty bucket should refuse")
+	assert.Equal(t, 20*time.Second, result.RetryAfter, "One token comes back every 20 seconds")
+	assert.Equal(t, time.Minute, result.Reset)
+
+	result, _ = store.Take("ip:192.0.2.2", 3, time.Minute)
+	assert.True(t, result.Allowed, "Other keys have their own bucket")
+
+	store.now = func() time.Time { return start.Add(20 * time.Second) }
+	result, _ = store.Take("ip:192.0.2.1", 3, time.Minute)
+	assert.True(t, result.Allowed, "The bucket should refill over time")
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func newLimitedRouter(limiter *RateLimiter) *mux.Router {
+	r := mux.NewRouter()
+	r.Handle("/auth/login", okHandler).Methods("POST")
+	r.Handle("/users/{id}", okHandler).Methods("GET")
+	r.Use(limiter.Middleware)
+	return r
+}
+
+func TestRateLimiterAppliesRoutePolicy(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), RatePolicy{Limit: 100, Period: time.Minute, Key: KeyByIP})
+	limiter.Route("POST", "/auth/login", RatePolicy{Limit: 2, Period: time.Minute, Key: KeyByIP})
+	router := newLimitedRouter(limiter)
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Should return status 429 Too Many Requests")
+	assert.Contains(t, rr.Body.String(), `"code":"rate_limited"`)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+
+	// Routes without their own policy fall back to the default, with a separate bucket
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/"+uuid.NewString(), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "100", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "99", rr.Header().Get("RateLimit-Remaining"))
+}
+
+func TestRateLimiterUnlimitedWithoutPolicy(t *testing.T) {
+	router := newLimitedRouter(NewRateLimiter(NewMemoryRateLimitStore(), RatePolicy{}))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"), "Unlimited routes carry no rate limit headers")
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(key string, limit int, period time.Duration) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimiterFailsOpen(t *testing.T) {
+	router := newLimitedRouter(NewRateLimiter(failingRateLimitStore{}, RatePolicy{Limit: 1, Period: time.Minute, Key: KeyByIP}))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "Requests should go through when the store fails")
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	tokens := utils.NewTokenManager([]byte("0123456789abcdef0123456789abcdef"), time.Minute, time.Hour)
+	userID := uuid.New()
+
+	req := bearerRequest(t, tokens, userID, uuid.New())
+	req.RemoteAddr = "192.0.2.1:51234"
+	assert.Equal(t, "user:"+userID.String(), KeyByUser(tokens)(req))
+	assert.Equal(t, "ip:192.0.2.1", KeyByIP(req))
+
+	req.Header.Set("Authorization", "Bearer forged")
+	assert.Equal(t, "ip:192.0.2.1", KeyByUser(tokens)(req), "Invalid tokens count against the address")
+}
@@ -0,0 +1,168 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Error loading migrations: %v", err)
+	}
+
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "Versions should be contiguous and ordered")
+		assert.NotEmpty(t, migration.Up, "Migration %d should have an up script", migration.Version)
+		assert.NotEmpty(t, migration.Down, "Migration %d should have a down script", migration.Version)
+	}
+}
+
+func TestLoadRejectsIncompleteMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_create_users.up.sql": {Data: []byte("CREATE TABLE users ();")},
+	}
+	_, err := load(fsys)
+	assert.Error(t, err, "A migration without a down script should be rejected")
+
+	fsys = fstest.MapFS{
+		"sql/create_users.sql": {Data: []byte("CREATE TABLE users ();")},
+	}
+	_, err = load(fsys)
+	assert.Error(t, err, "Files without a version should be rejected")
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator := &Migrator{db: db, migrations: []Migration{
+		{Version: 1, Name: "create_users", Up: "CREATE TABLE users ()", Down: "DROP TABLE users"},
+		{Version: 2, Name: "create_groups", Up: "CREATE TABLE groups ()", Down: "DROP TABLE groups"},
+	}}
+	return migrator, mock
+}
+
+func expectLock(mock sqlmock.Sqlmock, appliedVersions ...int64) {
+	mock.ExpectExec("SELECT pg_advisory_lock\\(\\$1\\)").
+		WithArgs(advisoryLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range appliedVersions {
+		rows.AddRow(version, time.Now())
+	}
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(rows)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").
+		WithArgs(advisoryLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE groups").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations \\(version, name\\) VALUES \\(\\$1, \\$2\\)").
+		WithArgs(int64(2), "create_groups").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	applied, err := migrator.Up()
+	assert.NoError(t, err)
+	if assert.Len(t, applied, 1, "Only the pending migration should run") {
+		assert.Equal(t, int64(2), applied[0].Version)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE users").WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	applied, err := migrator.Up()
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Empty(t, applied)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDownRevertsLatestMigration(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE groups").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version = \\$1").
+		WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	reverted, err := migrator.Down()
+	assert.NoError(t, err)
+	if assert.NotNil(t, reverted) {
+		assert.Equal(t, "create_groups", reverted.Name)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 1)
+	expectUnlock(mock)
+
+	statuses, err := migrator.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 2) {
+		assert.NotNil(t, statuses[0].AppliedAt, "Applied migrations should report when they ran")
+		assert.Nil(t, statuses[1].AppliedAt, "Pending migrations should have no timestamp")
+	}
+}
+
+func TestPendingSkipsTheLock(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(int64(1), time.Now()))
+
+	pending, err := migrator.Pending(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "create_groups", pending[0].Name)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS groups TEXT[];
+
+DROP TABLE IF EXISTS user_groups;
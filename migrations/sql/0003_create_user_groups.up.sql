@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_groups (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, group_id)
+);
+
+-- group membership lives in user_groups, drop the legacy array column
+ALTER TABLE users DROP COLUMN IF EXISTS groups;
@@ -0,0 +1,15 @@
+-- every refresh token is a row, rotations stay in the same family
+CREATE TABLE IF NOT EXISTS sessions (
+	id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	family_id UUID NOT NULL,
+	refresh_token_hash TEXT NOT NULL UNIQUE,
+	user_agent TEXT,
+	ip_address TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	rotated_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sessions_family_id_idx ON sessions (family_id);
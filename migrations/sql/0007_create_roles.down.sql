@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS group_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
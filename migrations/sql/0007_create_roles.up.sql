@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS roles (
+	id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	name TEXT NOT NULL UNIQUE,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	permission TEXT NOT NULL,
+	PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS group_roles (
+	group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+	role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	PRIMARY KEY (group_id, role_id)
+);
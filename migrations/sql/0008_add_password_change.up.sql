@@ -0,0 +1,3 @@
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP,
+	ADD COLUMN IF NOT EXISTS email_verification_sent_at TIMESTAMP;
+
+-- Accounts created before verification existed keep signing in when it is required
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
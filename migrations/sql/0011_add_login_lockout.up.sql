@@ -0,0 +1,3 @@
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
@@ -0,0 +1,14 @@
+-- Events outlive the users they mention, so the IDs are kept without foreign keys
+CREATE TABLE IF NOT EXISTS audit_events (
+	id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	actor_id UUID,
+	action TEXT NOT NULL,
+	target_user_id UUID,
+	changes JSONB,
+	ip_address TEXT,
+	user_agent TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_target_user_id_idx ON audit_events (target_user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, created_at);
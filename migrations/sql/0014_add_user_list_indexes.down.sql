@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_last_login_idx;
+DROP INDEX IF EXISTS users_created_at_idx;
+DROP INDEX IF EXISTS users_lower_username_idx;
+DROP INDEX IF EXISTS users_lower_email_idx;
+DROP INDEX IF EXISTS users_lower_name_idx;
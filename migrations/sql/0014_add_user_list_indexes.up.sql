@@ -0,0 +1,7 @@
+-- Prefix search on GET /users lowercases the searched columns
+CREATE INDEX IF NOT EXISTS users_lower_name_idx ON users (lower(name) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_lower_email_idx ON users (lower(email) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_lower_username_idx ON users (lower(username) text_pattern_ops);
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_last_login_idx ON users (last_login);
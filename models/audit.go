@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditUserCreated         = "user.created"
+	AuditUserUpdated         = "user.updated"
+	AuditUserDeleted         = "user.deleted"
+	AuditUserRestored        = "user.restored"
+	AuditUserPurged          = "user.purged"
+	AuditUserPasswordChanged = "user.password_changed"
+	AuditUserPasswordReset   = "user.password_reset"
+	AuditUserEmailVerified   = "user.email_verified"
+	AuditUserTOTPEnabled     = "user.totp_enabled"
+	AuditUserLocked          = "user.locked"
+	AuditUserUnlocked        = "user.unlocked"
+)
+
+// every action the audit log records, filters are checked against it
+var AuditActions = []string{
+	AuditUserCreated,
+	AuditUserUpdated,
+	AuditUserDeleted,
+	AuditUserRestored,
+	AuditUserPurged,
+	AuditUserPasswordChanged,
+	AuditUserPasswordReset,
+	AuditUserEmailVerified,
+	AuditUserTOTPEnabled,
+	AuditUserLocked,
+	AuditUserUnlocked,
+}
+
+type AuditEvent struct {
+	ID           uuid.UUID              `json:"id"`
+	ActorID      *uuid.UUID             `json:"actor_id,omitempty"`
+	Action       string                 `json:"action"`
+	TargetUserID *uuid.UUID             `json:"target_user_id,omitempty"`
+	Changes      map[string]interface{} `json:"changes,omitempty"`
+	IPAddress    string                 `json:"ip_address,omitempty"`
+	UserAgent    string                 `json:"user_agent,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+type PaginatedAuditEventsResponse struct {
+	Events      []AuditEvent `json:"events"`
+	Page        int          `json:"page"`
+	Limit       int          `json:"limit"`
+	TotalEvents int          `json:"total_events"`
+}
@@ -15,12 +15,15 @@ type User struct {
 	// Phone       string                 `json:"phone,omitempty"`
 	// Address     string                 `json:"address,omitempty"`
 	// DateOfBirth time.Time              `json:"date_of_birth,omitempty"`
-	CreatedAt   time.Time              `json:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at"`
-	LastLogin   time.Time              `json:"last_login,omitempty"`
-	IsActive    bool                   `json:"is_active"`
-	Groups      []Group                `json:"groups,omitempty"`
-	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+	LastLogin       time.Time              `json:"last_login,omitempty"`
+	EmailVerifiedAt *time.Time             `json:"email_verified_at,omitempty"`
+	DeletedAt       *time.Time             `json:"deleted_at,omitempty"`
+	IsActive        bool                   `json:"is_active"`
+	IsAdmin         bool                   `json:"is_admin,omitempty"`
+	Groups          []Group                `json:"groups,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
 }
 
 type Group struct {
@@ -38,3 +41,18 @@ type PaginatedResponse struct {
 	Limit      int    `json:"limit"`
 	TotalUsers int    `json:"total_users"`
 }
+
+// CursorPaginatedResponse is a page of a keyset walk, NextCursor is empty on the last page
+type CursorPaginatedResponse struct {
+	Users      []User `json:"users"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	TotalUsers *int   `json:"total_users,omitempty"`
+}
+
+type PaginatedGroupsResponse struct {
+	Groups      []Group `json:"groups"`
+	Page        int     `json:"page"`
+	Limit       int     `json:"limit"`
+	TotalGroups int     `json:"total_groups"`
+}
@@ -2,16 +2,88 @@ package routes
 
 import (
 	"database/sql"
+	"go-berry/config"
 	"go-berry/handlers"
+	"go-berry/mailer"
+	"go-berry/middleware"
+	"go-berry/migrations"
+	"go-berry/models"
+	"go-berry/store"
+	"go-berry/utils"
 
 	"github.com/gorilla/mux"
 )
 
-func InitializeRoutes(r *mux.Router, db *sql.DB) {
+func InitializeRoutes(r *mux.Router, db *sql.DB, migrator *migrations.Migrator, tokens *utils.TokenManager, cfg config.Config, mail *mailer.Queue) {
+	authConfig, mailConfig, limit := cfg.Auth, cfg.Mail, cfg.RateLimit
+	auth := middleware.NewAuth(tokens, db)
+	users := store.NewPostgresUserStore(db)
+	loginAttempts := handlers.NewLoginThrottle(authConfig)
 
-	r.HandleFunc("/users", handlers.GetAllUsers(db)).Methods("GET")
-	r.HandleFunc("/users/{id}", handlers.GetUser(db)).Methods("GET")
-	r.HandleFunc("/users", handlers.CreateUser(db)).Methods("POST")
-	r.HandleFunc("/users/{id}", handlers.UpdateUser(db)).Methods("PUT")
-	r.HandleFunc("/users/{id}", handlers.DeleteUser(db)).Methods("DELETE")
-}
\ No newline at end of file
+	// Authenticated callers share one allowance per user, the public auth routes are limited per address
+	limits := middleware.NewRateLimiter(middleware.NewMemoryRateLimitStore(), ratePolicy(limit.Default, middleware.KeyByUser(tokens)))
+	limits.Route("POST", "/auth/login", ratePolicy(limit.Login, middleware.KeyByIP))
+	limits.Route("POST", "/auth/login/mfa", ratePolicy(limit.Login, middleware.KeyByIP))
+	limits.Route("POST", "/auth/refresh", ratePolicy(limit.Refresh, middleware.KeyByIP))
+	limits.Route("POST", "/auth/forgot-password", ratePolicy(limit.ForgotPassword, middleware.KeyByIP))
+	limits.Route("POST", "/auth/reset-password", ratePolicy(limit.ResetPassword, middleware.KeyByIP))
+	limits.Route("POST", "/auth/verify-email", ratePolicy(limit.VerifyEmail, middleware.KeyByIP))
+	limits.Route("POST", "/auth/verify-email/resend", ratePolicy(limit.ResendVerification, middleware.KeyByIP))
+	limits.Route("POST", "/users", ratePolicy(limit.Signup, middleware.KeyByIP))
+	r.Use(middleware.RecordRoute)
+	r.Use(limits.Middleware)
+	r.Use(middleware.Deadline(cfg.Database.RequestTimeout))
+
+	r.Handle("/healthz", handlers.Healthz()).Methods("GET")
+	r.Handle("/readyz", handlers.Readyz(db, migrator)).Methods("GET")
+
+	r.Handle("/auth/login", handlers.Login(db, tokens, authConfig, loginAttempts)).Methods("POST")
+	r.Handle("/auth/login/mfa", handlers.LoginMFA(db, tokens, authConfig, loginAttempts)).Methods("POST")
+	r.Handle("/auth/refresh", handlers.RefreshToken(db, tokens)).Methods("POST")
+	r.Handle("/auth/forgot-password", handlers.ForgotPassword(db, mail, mailConfig)).Methods("POST")
+	r.Handle("/auth/reset-password", handlers.ResetForgottenPassword(db)).Methods("POST")
+	r.Handle("/auth/verify-email", handlers.VerifyEmail(db, tokens)).Methods("POST")
+	r.Handle("/auth/verify-email/resend", handlers.ResendVerificationEmail(db, tokens, mail, mailConfig)).Methods("POST")
+	r.Handle("/sessions/{id}", auth.Require(middleware.Authenticated, handlers.DeleteSession(db))).Methods("DELETE")
+
+	r.Handle("/users", auth.RequirePermission(models.PermissionUsersRead, handlers.GetAllUsers(users))).Methods("GET")
+	r.Handle("/users/{id}", auth.RequireSelfOrPermission("id", models.PermissionUsersRead, handlers.GetUser(users))).Methods("GET")
+	r.Handle("/users", auth.Require(middleware.Public, handlers.CreateUser(users, tokens, mail, mailConfig))).Methods("POST")
+	r.Handle("/users/{id}", auth.RequireSelfOrPermission("id", models.PermissionUsersWrite, handlers.UpdateUser(users))).Methods("PUT")
+	r.Handle("/users/{id}", auth.RequireSelfOrPermission("id", models.PermissionUsersWrite, handlers.PatchUser(users))).Methods("PATCH")
+	r.Handle("/users/{id}", auth.RequirePermission(models.PermissionUsersWrite, handlers.DeleteUser(users))).Methods("DELETE")
+	r.Handle("/users/{id}/password", auth.RequireSelfAllowingPasswordChange("id", handlers.ChangePassword(db, tokens))).Methods("POST")
+	r.Handle("/users/{id}/password/reset", auth.RequirePermission(models.PermissionUsersWrite, handlers.ResetPassword(db))).Methods("POST")
+	r.Handle("/users/{id}/unlock", auth.RequirePermission(models.PermissionUsersWrite, handlers.UnlockUser(db))).Methods("POST")
+	r.Handle("/users/{id}/restore", auth.RequirePermission(models.PermissionUsersWrite, handlers.RestoreUser(users))).Methods("POST")
+	r.Handle("/users/{id}/groups", auth.RequireSelfOrPermission("id", models.PermissionUsersRead, handlers.GetUserGroups(users))).Methods("GET")
+	r.Handle("/users/{id}/permissions", auth.RequireSelfOrPermission("id", models.PermissionUsersRead, handlers.GetUserPermissions(db))).Methods("GET")
+	r.Handle("/users/{id}/sessions", auth.RequireSelfOrAdmin("id", handlers.GetUserSessions(db))).Methods("GET")
+	r.Handle("/users/{id}/2fa/totp", auth.RequireSelfOrAdmin("id", handlers.EnrollTOTP(db))).Methods("POST")
+	r.Handle("/users/{id}/2fa/totp/confirm", auth.RequireSelfOrAdmin("id", handlers.ConfirmTOTP(db))).Methods("POST")
+
+	r.Handle("/groups", auth.Require(middleware.Authenticated, handlers.GetAllGroups(db))).Methods("GET")
+	r.Handle("/groups/{id}", auth.Require(middleware.Authenticated, handlers.GetGroup(db))).Methods("GET")
+	r.Handle("/groups", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.CreateGroup(db))).Methods("POST")
+	r.Handle("/groups/{id}", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.UpdateGroup(db))).Methods("PUT")
+	r.Handle("/groups/{id}", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.DeleteGroup(db))).Methods("DELETE")
+	r.Handle("/groups/{id}/members", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.GetGroupMembers(db))).Methods("GET")
+	r.Handle("/groups/{id}/members/{userId}", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.AddGroupMember(db))).Methods("POST")
+	r.Handle("/groups/{id}/members/{userId}", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.RemoveGroupMember(db))).Methods("DELETE")
+	r.Handle("/groups/{id}/roles", auth.RequirePermission(models.PermissionGroupsAdmin, handlers.GetGroupRoles(db))).Methods("GET")
+	r.Handle("/groups/{id}/roles/{roleId}", auth.RequirePermission(models.PermissionRolesAdmin, handlers.AddGroupRole(db))).Methods("POST")
+	r.Handle("/groups/{id}/roles/{roleId}", auth.RequirePermission(models.PermissionRolesAdmin, handlers.RemoveGroupRole(db))).Methods("DELETE")
+
+	r.Handle("/permissions", auth.Require(middleware.Authenticated, handlers.GetPermissions())).Methods("GET")
+	r.Handle("/roles", auth.RequirePermission(models.PermissionRolesAdmin, handlers.GetAllRoles(db))).Methods("GET")
+	r.Handle("/roles/{id}", auth.RequirePermission(models.PermissionRolesAdmin, handlers.GetRole(db))).Methods("GET")
+	r.Handle("/roles", auth.RequirePermission(models.PermissionRolesAdmin, handlers.CreateRole(db))).Methods("POST")
+	r.Handle("/roles/{id}", auth.RequirePermission(models.PermissionRolesAdmin, handlers.UpdateRole(db))).Methods("PUT")
+	r.Handle("/roles/{id}", auth.RequirePermission(models.PermissionRolesAdmin, handlers.DeleteRole(db))).Methods("DELETE")
+
+	r.Handle("/audit-events", auth.RequirePermission(models.PermissionAuditRead, handlers.GetAuditEvents(store.NewPostgresAuditStore(db)))).Methods("GET")
+}
+
+func ratePolicy(limit config.RateLimit, key middleware.KeyFunc) middleware.RatePolicy {
+	return middleware.RatePolicy{Limit: limit.Limit, Period: limit.Period, Key: key}
+}
@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/google/uuid"
+)
+
+// AuditContext describes who made a change and from where, it travels with every user mutation
+type AuditContext struct {
+	ActorID   *uuid.UUID // nil for anonymous callers, such as someone signing up
+	IPAddress string
+	UserAgent string
+}
+
+// builds the event for a change of the target user
+func (c AuditContext) Event(action string, targetID uuid.UUID, changes map[string]interface{}) models.AuditEvent {
+	return models.AuditEvent{
+		ID:           uuid.New(),
+		ActorID:      c.ActorID,
+		Action:       action,
+		TargetUserID: &targetID,
+		Changes:      changes,
+		IPAddress:    c.IPAddress,
+		UserAgent:    c.UserAgent,
+		CreatedAt:    time.Now(),
+	}
+}
+
+// AuditFilter selects a page of audit events, zero fields match everything
+type AuditFilter struct {
+	ActorID      *uuid.UUID
+	TargetUserID *uuid.UUID
+	Action       string
+	From         time.Time // inclusive
+	To           time.Time // exclusive
+	Limit        int
+	Offset       int
+}
+
+func (f AuditFilter) matches(event models.AuditEvent) bool {
+	switch {
+	case f.ActorID != nil && (event.ActorID == nil || *event.ActorID != *f.ActorID):
+		return false
+	case f.TargetUserID != nil && (event.TargetUserID == nil || *event.TargetUserID != *f.TargetUserID):
+		return false
+	case f.Action != "" && event.Action != f.Action:
+		return false
+	case !f.From.IsZero() && event.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !event.CreatedAt.Before(f.To):
+		return false
+	}
+	return true
+}
+
+// AuditStore reads the audit log, events are written by the stores whose changes they record
+type AuditStore interface {
+	// List returns a page of matching events, newest first, and the number of matching events
+	List(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, int, error)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// writes an event with the given handle, pass the transaction of the change it records
+func InsertAuditEvent(ctx context.Context, db execer, event models.AuditEvent) error {
+	changes, err := MarshalMetadata(event.Changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(
+		ctx,
+		"INSERT INTO audit_events (id, actor_id, action, target_user_id, changes, ip_address, user_agent, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		event.ID, event.ActorID, event.Action, event.TargetUserID, changes, event.IPAddress, event.UserAgent, event.CreatedAt,
+	)
+	return err
+}
+
+// lists the audited fields that differ between two versions of a user as {"field": {"from": ..., "to": ...}}.
+// A nil before records a creation and a nil after a deletion. The password is never part of it.
+func UserChanges(before, after *models.User) map[string]interface{} {
+	fields := func(user *models.User) map[string]interface{} {
+		if user == nil {
+			return nil
+		}
+		return map[string]interface{}{
+			"name":              user.Name,
+			"email":             user.Email,
+			"username":          user.Username,
+			"is_active":         user.IsActive,
+			"email_verified_at": user.EmailVerifiedAt,
+			"metadata":          user.Metadata,
+			"deleted_at":        user.DeletedAt,
+		}
+	}
+	from, to := fields(before), fields(after)
+
+	changes := map[string]interface{}{}
+	for _, name := range []string{"name", "email", "username", "is_active", "email_verified_at", "metadata", "deleted_at"} {
+		change := map[string]interface{}{}
+		switch {
+		case from == nil:
+			if !isEmpty(to[name]) {
+				change["to"] = to[name]
+			}
+		case to == nil:
+			if !isEmpty(from[name]) {
+				change["from"] = from[name]
+			}
+		case isEmpty(from[name]) && isEmpty(to[name]), reflect.DeepEqual(from[name], to[name]):
+			// unchanged
+		default:
+			change["from"] = from[name]
+			change["to"] = to[name]
+		}
+		if len(change) > 0 {
+			changes[name] = change
+		}
+	}
+	return changes
+}
+
+func isEmpty(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	return !v.IsValid() || v.IsZero() || ((v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.Len() == 0)
+}
+
+// PostgresAuditStore reads the audit_events table
+type PostgresAuditStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAuditStore(db *sql.DB) *PostgresAuditStore {
+	return &PostgresAuditStore{db: db}
+}
+
+func (s *PostgresAuditStore) List(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, int, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != nil {
+		where("actor_id = $%d", *filter.ActorID)
+	}
+	if filter.TargetUserID != nil {
+		where("target_user_id = $%d", *filter.TargetUserID)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+
+	clause := ""
+	if len(conditions) > 0 {
+		clause = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events"+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	rows, err := s.db.QueryContext(
+		ctx,
+		fmt.Sprintf(
+			"SELECT id, actor_id, action, target_user_id, changes, ip_address, user_agent, created_at FROM audit_events%s ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d",
+			clause, len(args)-1, len(args),
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var actorID, targetID uuid.NullUUID
+		var ipAddress, userAgent sql.NullString
+		var changes []byte
+		if err := rows.Scan(&event.ID, &actorID, &event.Action, &targetID, &changes, &ipAddress, &userAgent, &event.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		if actorID.Valid {
+			event.ActorID = &actorID.UUID
+		}
+		if targetID.Valid {
+			event.TargetUserID = &targetID.UUID
+		}
+		event.IPAddress = ipAddress.String
+		event.UserAgent = userAgent.String
+		if event.Changes, err = UnmarshalMetadata(changes); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
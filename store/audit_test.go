@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserChanges(t *testing.T) {
+	before := &models.User{Name: "Ada Lovelace", Email: "ada@example.com", Password: "hash", IsActive: true}
+	after := *before
+	after.Name = "Augusta Ada King"
+	after.Password = "another hash"
+	after.Metadata = map[string]interface{}{"theme": "dark"}
+
+	changes := UserChanges(before, &after)
+	assert.Equal(t, map[string]interface{}{
+		"name":     map[string]interface{}{"from": "Ada Lovelace", "to": "Augusta Ada King"},
+		"metadata": map[string]interface{}{"from": map[string]interface{}(nil), "to": after.Metadata},
+	}, changes, "Only changed fields should be listed, never the password")
+
+	created := UserChanges(nil, before)
+	assert.Equal(t, map[string]interface{}{"to": "ada@example.com"}, created["email"])
+	assert.NotContains(t, created, "username", "Empty fields are left out of creations")
+
+	deleted := UserChanges(before, nil)
+	assert.Equal(t, map[string]interface{}{"from": true}, deleted["is_active"])
+}
+
+func TestMemoryAuditStoreRecordsMutations(t *testing.T) {
+	users := NewMemoryUserStore()
+	actorID := uuid.New()
+	audit := AuditContext{ActorID: &actorID, IPAddress: "192.0.2.1", UserAgent: "test"}
+
+	user := models.User{ID: uuid.New(), Name: "Ada Lovelace", Email: "ada@example.com"}
+	users.Create(context.Background(), &user, AuditContext{})
+	name := "Augusta Ada King"
+	users.Update(context.Background(), user.ID, UserUpdate{Name: &name, UpdatedAt: time.Now()}, audit)
+	users.Delete(context.Background(), user.ID, audit)
+
+	events, total, err := users.Audit().List(context.Background(), AuditFilter{TargetUserID: &user.ID, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, models.AuditUserDeleted, events[0].Action, "Events should be listed newest first")
+	assert.Equal(t, models.AuditUserCreated, events[2].Action)
+	assert.Nil(t, events[2].ActorID, "Anonymous sign ups have no actor")
+
+	events, total, _ = users.Audit().List(context.Background(), AuditFilter{ActorID: &actorID, Action: models.AuditUserUpdated, Limit: 10})
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "192.0.2.1", events[0].IPAddress)
+
+	_, total, _ = users.Audit().List(context.Background(), AuditFilter{From: time.Now().Add(time.Minute), Limit: 10})
+	assert.Equal(t, 0, total, "Events before the range should be left out")
+}
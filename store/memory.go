@@ -0,0 +1,368 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/google/uuid"
+)
+
+// MemoryUserStore keeps users in a map, for tests and local experiments. Like the database it fails
+// with the context's error once the context is done, so cancellation can be tested without one.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]models.User
+	audit *MemoryAuditStore
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[uuid.UUID]models.User{}, audit: NewMemoryAuditStore()}
+}
+
+// the audit log the store's mutations are recorded in
+func (s *MemoryUserStore) Audit() *MemoryAuditStore {
+	return s.audit
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user *models.User, audit AuditContext) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = *user
+	s.audit.record(audit.Event(models.AuditUserCreated, user.ID, UserChanges(nil, user)))
+	return nil
+}
+
+func (s *MemoryUserStore) Get(ctx context.Context, id uuid.UUID, includeDeleted bool) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || (user.DeletedAt != nil && !includeDeleted) {
+		return nil, ErrNotFound
+	}
+	// Groups come ordered by name, as PostgresUserStore lists them
+	user.Groups = append([]models.Group{}, user.Groups...)
+	sort.Slice(user.Groups, func(i, j int) bool { return user.Groups[i].Name < user.Groups[j].Name })
+	return &user, nil
+}
+
+// filters and orders users the way PostgresUserStore does
+func (s *MemoryUserStore) List(ctx context.Context, opts ListOptions) ([]models.User, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	desc := isDescending(opts.Sort)
+	all := make([]models.User, 0, len(s.users))
+	total := 0
+	for _, user := range s.users {
+		if !opts.matches(user) {
+			continue
+		}
+		total++
+		if opts.After != nil && !afterCursor(user, *opts.After, desc) {
+			continue
+		}
+		all = append(all, user)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		for _, field := range opts.Sort {
+			if c := compareUsers(all[i], all[j], field); c != 0 {
+				return c < 0
+			}
+		}
+		if desc {
+			return all[i].ID.String() > all[j].ID.String()
+		}
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.Before(all[j].CreatedAt)
+		}
+		return all[i].ID.String() < all[j].ID.String()
+	})
+
+	users := []models.User{}
+	for i := opts.Offset; i < len(all) && len(users) < opts.Limit; i++ {
+		users = append(users, all[i])
+	}
+	if opts.SkipCount {
+		total = 0
+	}
+	return users, total, nil
+}
+
+// reports whether the user comes after the cursor in created_at, id order
+func afterCursor(user models.User, cursor UserCursor, desc bool) bool {
+	c := user.CreatedAt.Compare(cursor.CreatedAt)
+	if c == 0 {
+		c = strings.Compare(user.ID.String(), cursor.ID.String())
+	}
+	if desc {
+		return c < 0
+	}
+	return c > 0
+}
+
+func (opts ListOptions) matches(user models.User) bool {
+	inRange := func(t, from, to time.Time) bool {
+		return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+	}
+	hasPrefix := func(value string) bool {
+		return strings.HasPrefix(strings.ToLower(value), strings.ToLower(opts.Query))
+	}
+
+	switch {
+	case user.DeletedAt != nil && !opts.IncludeDeleted:
+		return false
+	case opts.Email != "" && !strings.EqualFold(user.Email, opts.Email):
+		return false
+	case opts.Username != "" && !strings.EqualFold(user.Username, opts.Username):
+		return false
+	case opts.IsActive != nil && user.IsActive != *opts.IsActive:
+		return false
+	case opts.GroupID != nil && !inGroup(user, *opts.GroupID):
+		return false
+	case !inRange(user.CreatedAt, opts.CreatedFrom, opts.CreatedTo):
+		return false
+	case (!opts.LastLoginFrom.IsZero() || !opts.LastLoginTo.IsZero()) && (user.LastLogin.IsZero() || !inRange(user.LastLogin, opts.LastLoginFrom, opts.LastLoginTo)):
+		return false
+	case opts.Query != "" && !hasPrefix(user.Name) && !hasPrefix(user.Email) && !(user.Username != "" && hasPrefix(user.Username)):
+		return false
+	}
+	return true
+}
+
+func inGroup(user models.User, groupID uuid.UUID) bool {
+	for _, group := range user.Groups {
+		if group.ID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// compares two users on a sort field, empty values come last in either direction
+func compareUsers(a, b models.User, field SortField) int {
+	var c int
+	var aEmpty, bEmpty bool
+	switch field.Field {
+	case "name":
+		c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	case "email":
+		c = strings.Compare(strings.ToLower(a.Email), strings.ToLower(b.Email))
+	case "username":
+		c = strings.Compare(strings.ToLower(a.Username), strings.ToLower(b.Username))
+		aEmpty, bEmpty = a.Username == "", b.Username == ""
+	case "created_at":
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	case "last_login":
+		c = a.LastLogin.Compare(b.LastLogin)
+		aEmpty, bEmpty = a.LastLogin.IsZero(), b.LastLogin.IsZero()
+	}
+
+	switch {
+	case aEmpty && bEmpty:
+		return 0
+	case aEmpty:
+		return 1
+	case bEmpty:
+		return -1
+	case field.Desc:
+		return -c
+	}
+	return c
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, id uuid.UUID, update UserUpdate, audit AuditContext) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	before := user
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Email != nil {
+		if user.Email != *update.Email {
+			user.EmailVerifiedAt = nil
+		}
+		user.Email = *update.Email
+	}
+	if update.Username != nil {
+		user.Username = *update.Username
+	}
+	if update.IsActive != nil {
+		user.IsActive = *update.IsActive
+	}
+	if update.Metadata != nil {
+		user.Metadata = *update.Metadata
+		if len(user.Metadata) == 0 {
+			user.Metadata = nil
+		}
+	}
+	user.UpdatedAt = update.UpdatedAt
+	s.users[id] = user
+	s.audit.record(audit.Event(models.AuditUserUpdated, id, UserChanges(&before, &user)))
+	return &user, nil
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, id uuid.UUID, audit AuditContext) (*models.User, error) {
+	now := time.Now()
+	return s.setDeletedAt(ctx, id, &now, models.AuditUserDeleted, audit)
+}
+
+func (s *MemoryUserStore) Restore(ctx context.Context, id uuid.UUID, audit AuditContext) (*models.User, error) {
+	return s.setDeletedAt(ctx, id, nil, models.AuditUserRestored, audit)
+}
+
+func (s *MemoryUserStore) setDeletedAt(ctx context.Context, id uuid.UUID, deletedAt *time.Time, action string, audit AuditContext) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || (user.DeletedAt == nil) == (deletedAt == nil) {
+		return nil, ErrNotFound
+	}
+	before := user
+	user.DeletedAt = deletedAt
+	user.UpdatedAt = time.Now()
+	s.users[id] = user
+	s.audit.record(audit.Event(action, id, UserChanges(&before, &user)))
+	return &user, nil
+}
+
+func (s *MemoryUserStore) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, user := range s.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(deletedBefore) {
+			delete(s.users, id)
+			s.audit.record(AuditContext{}.Event(models.AuditUserPurged, id, UserChanges(&user, nil)))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryUserStore) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryUserStore) ExistsByUsername(ctx context.Context, username string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Username != "" && strings.EqualFold(user.Username, username) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// groups are not kept in memory, so only the admin flag can grant anything
+func (s *MemoryUserStore) Privileges(ctx context.Context, id uuid.UUID) (bool, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return false, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return false, nil, ErrNotFound
+	}
+	return user.IsAdmin, nil, nil
+}
+
+// MemoryAuditStore keeps audit events in a slice, it is filled by MemoryUserStore
+type MemoryAuditStore struct {
+	mu     sync.RWMutex
+	events []models.AuditEvent
+}
+
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+func (s *MemoryAuditStore) record(event models.AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+}
+
+func (s *MemoryAuditStore) List(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Newest first, events are appended in the order they happen
+	matching := []models.AuditEvent{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if filter.matches(s.events[i]) {
+			matching = append(matching, s.events[i])
+		}
+	}
+
+	events := []models.AuditEvent{}
+	for i := filter.Offset; i < len(matching) && len(events) < filter.Limit; i++ {
+		events = append(events, matching[i])
+	}
+	return events, len(matching), nil
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryUserStoreRoundTrip(t *testing.T) {
+	users := NewMemoryUserStore()
+
+	user := models.User{ID: uuid.New(), Name: "Ada Lovelace", Email: "ada@example.com", CreatedAt: time.Now(), IsActive: true}
+	assert.NoError(t, users.Create(context.Background(), &user, AuditContext{}))
+
+	exists, err := users.ExistsByEmail(context.Background(), "ADA@example.com")
+	assert.NoError(t, err)
+	assert.True(t, exists, "Email lookups should ignore case")
+
+	name := "Augusta Ada King"
+	updated, err := users.Update(context.Background(), user.ID, UserUpdate{Name: &name, UpdatedAt: time.Now()}, AuditContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Augusta Ada King", updated.Name)
+
+	stored, err := users.Get(context.Background(), user.ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Augusta Ada King", stored.Name)
+	assert.Equal(t, "ada@example.com", stored.Email, "Update should only write the given fields")
+	assert.True(t, stored.IsActive, "Update should only write the given fields")
+
+	deleted, err := users.Delete(context.Background(), user.ID, AuditContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, user.Email, deleted.Email)
+
+	_, err = users.Get(context.Background(), user.ID, false)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = users.Update(context.Background(), user.ID, UserUpdate{Name: &name}, AuditContext{})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryUserStoreList(t *testing.T) {
+	users := NewMemoryUserStore()
+
+	start := time.Now()
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		user := models.User{ID: uuid.New(), Name: "user", Email: uuid.NewString() + "@example.com", CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		users.Create(context.Background(), &user, AuditContext{})
+		ids = append(ids, user.ID)
+	}
+
+	page, total, err := users.List(context.Background(), ListOptions{Limit: 2, Offset: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, total)
+	assert.Len(t, page, 2)
+	assert.Equal(t, ids[2], page[0].ID, "Users should be listed oldest first")
+	assert.Equal(t, ids[3], page[1].ID)
+
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, Offset: 10})
+	assert.Empty(t, page)
+}
+
+func TestMemoryUserStoreEmailChangeClearsVerification(t *testing.T) {
+	users := NewMemoryUserStore()
+
+	verifiedAt := time.Now()
+	user := models.User{ID: uuid.New(), Name: "Ada Lovelace", Email: "ada@example.com", EmailVerifiedAt: &verifiedAt}
+	assert.NoError(t, users.Create(context.Background(), &user, AuditContext{}))
+
+	same := "ada@example.com"
+	updated, err := users.Update(context.Background(), user.ID, UserUpdate{Email: &same, UpdatedAt: time.Now()}, AuditContext{})
+	assert.NoError(t, err)
+	assert.NotNil(t, updated.EmailVerifiedAt, "Writing the same email should keep it verified")
+
+	other := "augusta@example.com"
+	updated, err = users.Update(context.Background(), user.ID, UserUpdate{Email: &other, UpdatedAt: time.Now()}, AuditContext{})
+	assert.NoError(t, err)
+	assert.Nil(t, updated.EmailVerifiedAt, "A new email should need verifying again")
+}
+
+func TestMemoryUserStoreSoftDelete(t *testing.T) {
+	users := NewMemoryUserStore()
+
+	user := models.User{ID: uuid.New(), Name: "Ada Lovelace", Email: "ada@example.com", CreatedAt: time.Now()}
+	assert.NoError(t, users.Create(context.Background(), &user, AuditContext{}))
+
+	deleted, err := users.Delete(context.Background(), user.ID, AuditContext{})
+	assert.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+
+	_, total, _ := users.List(context.Background(), ListOptions{Limit: 10})
+	assert.Equal(t, 0, total, "Deleted users should be hidden by default")
+	page, total, _ := users.List(context.Background(), ListOptions{Limit: 10, IncludeDeleted: true})
+	assert.Equal(t, 1, total)
+	assert.Equal(t, user.ID, page[0].ID)
+	stored, err := users.Get(context.Background(), user.ID, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, stored.DeletedAt)
+
+	_, err = users.Delete(context.Background(), user.ID, AuditContext{})
+	assert.Equal(t, ErrNotFound, err, "A deleted user cannot be deleted again")
+
+	restored, err := users.Restore(context.Background(), user.ID, AuditContext{})
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	_, err = users.Get(context.Background(), user.ID, false)
+	assert.NoError(t, err)
+	_, err = users.Restore(context.Background(), user.ID, AuditContext{})
+	assert.Equal(t, ErrNotFound, err, "Only deleted users can be restored")
+
+	users.Delete(context.Background(), user.ID, AuditContext{})
+	purged, err := users.Purge(context.Background(), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged, "Users deleted within the retention should be kept")
+	purged, err = users.Purge(context.Background(), time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, err = users.Get(context.Background(), user.ID, true)
+	assert.Equal(t, ErrNotFound, err)
+
+	events, _, _ := users.Audit().List(context.Background(), AuditFilter{TargetUserID: &user.ID, Limit: 10})
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+	}
+	assert.Equal(t, []string{models.AuditUserPurged, models.AuditUserDeleted, models.AuditUserRestored, models.AuditUserDeleted, models.AuditUserCreated}, actions)
+}
+
+func TestMemoryUserStoreListFiltersAndSorts(t *testing.T) {
+	users := NewMemoryUserStore()
+
+	start := time.Now()
+	groupID := uuid.New()
+	for i, name := range []string{"Ada Lovelace", "Grace Hopper", "Adele Goldberg", "Alan Turing"} {
+		user := models.User{ID: uuid.New(), Name: name, Email: strings.ToLower(strings.Fields(name)[0]) + "@example.com", CreatedAt: start.Add(time.Duration(i) * time.Minute), IsActive: i != 3}
+		if i%2 == 0 {
+			user.Groups = []models.Group{{ID: groupID}}
+			user.LastLogin = start.Add(time.Duration(i) * time.Hour)
+		}
+		users.Create(context.Background(), &user, AuditContext{})
+	}
+
+	page, total, _ := users.List(context.Background(), ListOptions{Limit: 10, Query: "ad"})
+	assert.Equal(t, 2, total, "q should match name prefixes ignoring case")
+	assert.Equal(t, "Ada Lovelace", page[0].Name)
+
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, Query: "ALAN@"})
+	assert.Len(t, page, 1, "q should match email prefixes")
+
+	active := false
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, IsActive: &active})
+	assert.Len(t, page, 1)
+	assert.Equal(t, "Alan Turing", page[0].Name)
+
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, GroupID: &groupID, Sort: []SortField{{Field: "name", Desc: true}}})
+	assert.Equal(t, []string{"Adele Goldberg", "Ada Lovelace"}, []string{page[0].Name, page[1].Name})
+
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, Sort: []SortField{{Field: "last_login", Desc: true}}})
+	assert.Equal(t, "Adele Goldberg", page[0].Name)
+	assert.True(t, page[3].LastLogin.IsZero(), "Users that never logged in should come last")
+
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, LastLoginFrom: start.Add(time.Hour)})
+	assert.Len(t, page, 1)
+	page, _, _ = users.List(context.Background(), ListOptions{Limit: 10, CreatedFrom: start.Add(time.Minute), CreatedTo: start.Add(3 * time.Minute)})
+	assert.Len(t, page, 2)
+}
+
+func TestMemoryUserStoreHonoursCancellation(t *testing.T) {
+	users := NewMemoryUserStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	user := models.User{ID: uuid.New(), Name: "Ada Lovelace", Email: "ada@example.com", CreatedAt: time.Now()}
+	assert.ErrorIs(t, users.Create(ctx, &user, AuditContext{}), context.Canceled)
+
+	_, err := users.Get(context.Background(), user.ID, false)
+	assert.ErrorIs(t, err, ErrNotFound, "A cancelled call should not write anything")
+}
@@ -0,0 +1,26 @@
+package store
+
+import "encoding/json"
+
+// encodes metadata for a JSONB column, storing NULL when it is empty
+func MarshalMetadata(metadata map[string]interface{}) (interface{}, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func UnmarshalMetadata(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// returns the union of the permissions granted by the roles of every group the user belongs to.
+// Pass a transaction to read them alongside rows it has locked.
+func EffectivePermissions(ctx context.Context, db querier, userID uuid.UUID) ([]string, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT DISTINCT rp.permission FROM user_groups ug
+		JOIN group_roles gr ON gr.group_id = ug.group_id
+		JOIN role_permissions rp ON rp.role_id = gr.role_id
+		WHERE ug.user_id = $1 ORDER BY rp.permission`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
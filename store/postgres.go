@@ -0,0 +1,460 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/google/uuid"
+)
+
+const userColumns = "id, name, email, email_verified_at, username, created_at, updated_at, last_login, is_active, metadata, deleted_at"
+
+// PostgresUserStore keeps users in the users table
+type PostgresUserStore struct {
+	db *sql.DB
+}
+
+func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+	return &PostgresUserStore{db: db}
+}
+
+func (s *PostgresUserStore) Create(ctx context.Context, user *models.User, audit AuditContext) error {
+	// Use a transaction for atomicity
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO users (id, name, email, password, created_at, updated_at, is_active) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		user.ID, user.Name, user.Email, user.Password, user.CreatedAt, user.UpdatedAt, user.IsActive,
+	)
+	if err == nil {
+		err = InsertAuditEvent(ctx, tx, audit.Event(models.AuditUserCreated, user.ID, UserChanges(nil, user)))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresUserStore) Get(ctx context.Context, id uuid.UUID, includeDeleted bool) (*models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	user.Groups, err = s.userGroups(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *PostgresUserStore) List(ctx context.Context, opts ListOptions) ([]models.User, int, error) {
+	clause, args := userListFilter(opts)
+
+	// Fetch total number of users for pagination metadata
+	var totalUsers int
+	if !opts.SkipCount {
+		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+clause, args...).Scan(&totalUsers)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// The keyset condition only narrows the page, the total counts every matching user
+	if opts.After != nil {
+		comparison := ">"
+		if isDescending(opts.Sort) {
+			comparison = "<"
+		}
+		args = append(args, opts.After.CreatedAt, opts.After.ID)
+		condition := fmt.Sprintf("(created_at, id) %s ($%d, $%d)", comparison, len(args)-1, len(args))
+		if clause == "" {
+			clause = " WHERE " + condition
+		} else {
+			clause += " AND " + condition
+		}
+	}
+
+	args = append(args, opts.Limit, opts.Offset)
+	query := fmt.Sprintf("SELECT %s FROM users%s ORDER BY %s LIMIT $%d OFFSET $%d", userColumns, clause, userListOrder(opts.Sort), len(args)-1, len(args))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, totalUsers, nil
+}
+
+// builds the WHERE clause selecting the listed users, every value is passed as a parameter
+func userListFilter(opts ListOptions) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "$?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if !opts.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if opts.Email != "" {
+		where("lower(email) = lower($?)", opts.Email)
+	}
+	if opts.Username != "" {
+		where("lower(username) = lower($?)", opts.Username)
+	}
+	if opts.IsActive != nil {
+		where("is_active = $?", *opts.IsActive)
+	}
+	if opts.GroupID != nil {
+		where("EXISTS (SELECT 1 FROM user_groups ug WHERE ug.user_id = users.id AND ug.group_id = $?)", *opts.GroupID)
+	}
+	if !opts.CreatedFrom.IsZero() {
+		where("created_at >= $?", opts.CreatedFrom)
+	}
+	if !opts.CreatedTo.IsZero() {
+		where("created_at < $?", opts.CreatedTo)
+	}
+	if !opts.LastLoginFrom.IsZero() {
+		where("last_login >= $?", opts.LastLoginFrom)
+	}
+	if !opts.LastLoginTo.IsZero() {
+		where("last_login < $?", opts.LastLoginTo)
+	}
+	if opts.Query != "" {
+		where("(lower(name) LIKE $? OR lower(email) LIKE $? OR lower(username) LIKE $?)", escapeLike(strings.ToLower(opts.Query))+"%")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// builds the ORDER BY list, fields outside UserSortFields are skipped so nothing but known columns reaches the query
+func userListOrder(sort []SortField) string {
+	var order []string
+	for _, field := range sort {
+		if !IsUserSortField(field.Field) {
+			continue
+		}
+		direction := "ASC"
+		if field.Desc {
+			direction = "DESC"
+		}
+		order = append(order, field.Field+" "+direction+" NULLS LAST")
+	}
+	if isDescending(sort) {
+		// Newest first keeps ties newest first as well, so keyset pages line up
+		return strings.Join(append(order, "id DESC"), ", ")
+	}
+	return strings.Join(append(order, "created_at ASC", "id ASC"), ", ")
+}
+
+// reports whether users are ordered by created_at alone, newest first
+func isDescending(sort []SortField) bool {
+	return len(sort) == 1 && sort[0].Field == "created_at" && sort[0].Desc
+}
+
+// reports whether users can be sorted by the field
+func IsUserSortField(field string) bool {
+	for _, allowed := range UserSortFields {
+		if field == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// escapes the LIKE wildcards in a search term so it only matches literally
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+}
+
+func (s *PostgresUserStore) Update(ctx context.Context, id uuid.UUID, update UserUpdate, audit AuditContext) (*models.User, error) {
+	var assignments []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if update.Name != nil {
+		set("name", *update.Name)
+	}
+	if update.Email != nil {
+		// A new address has to be verified again, evaluated before email itself is reassigned
+		args = append(args, *update.Email)
+		assignments = append(assignments, fmt.Sprintf("email_verified_at = CASE WHEN email = $%d THEN email_verified_at END", len(args)))
+		set("email", *update.Email)
+	}
+	if update.Username != nil {
+		set("username", sql.NullString{String: *update.Username, Valid: *update.Username != ""})
+	}
+	if update.IsActive != nil {
+		set("is_active", *update.IsActive)
+	}
+	if update.Metadata != nil {
+		metadata, err := MarshalMetadata(*update.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		set("metadata", metadata)
+	}
+	set("updated_at", update.UpdatedAt)
+	args = append(args, id)
+
+	// Use a transaction for atomicity
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous version is locked so the recorded changes are the ones this update made
+	before, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id))
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d RETURNING %s", strings.Join(assignments, ", "), len(args), userColumns)
+	user, err := scanUser(tx.QueryRowContext(ctx, query, args...))
+	if err == nil {
+		err = InsertAuditEvent(ctx, tx, audit.Event(models.AuditUserUpdated, id, UserChanges(before, user)))
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *PostgresUserStore) Delete(ctx context.Context, id uuid.UUID, audit AuditContext) (*models.User, error) {
+	return s.setDeletedAt(ctx, id, sql.NullTime{Time: time.Now(), Valid: true}, models.AuditUserDeleted, audit)
+}
+
+func (s *PostgresUserStore) Restore(ctx context.Context, id uuid.UUID, audit AuditContext) (*models.User, error) {
+	return s.setDeletedAt(ctx, id, sql.NullTime{}, models.AuditUserRestored, audit)
+}
+
+// soft-deletes a user when deletedAt is valid and restores it otherwise, a user already in the
+// requested state is not found
+func (s *PostgresUserStore) setDeletedAt(ctx context.Context, id uuid.UUID, deletedAt sql.NullTime, action string, audit AuditContext) (*models.User, error) {
+	// Use a transaction for atomicity
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	condition := "deleted_at IS NULL"
+	if !deletedAt.Valid {
+		condition = "deleted_at IS NOT NULL"
+	}
+	before, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND "+condition+" FOR UPDATE", id))
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	user, err := scanUser(tx.QueryRowContext(ctx, "UPDATE users SET deleted_at = $1, updated_at = $2 WHERE id = $3 RETURNING "+userColumns, deletedAt, now, id))
+	if err == nil && deletedAt.Valid {
+		// A deleted user is signed out everywhere, restoring it does not bring the sessions back
+		_, err = tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", now, id)
+	}
+	if err == nil {
+		err = InsertAuditEvent(ctx, tx, audit.Event(action, id, UserChanges(before, user)))
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *PostgresUserStore) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	// Use a transaction for atomicity
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "DELETE FROM users WHERE deleted_at < $1 RETURNING "+userColumns, deletedBefore)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	purged := []*models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		purged = append(purged, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// The purge runs on its own, so its events have no actor
+	for _, user := range purged {
+		if err := InsertAuditEvent(ctx, tx, AuditContext{}.Event(models.AuditUserPurged, user.ID, UserChanges(user, nil))); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(purged), nil
+}
+
+func (s *PostgresUserStore) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE lower(email)=lower($1))", email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (s *PostgresUserStore) ExistsByUsername(ctx context.Context, username string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE lower(username)=lower($1))", username).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (s *PostgresUserStore) Privileges(ctx context.Context, id uuid.UUID) (bool, []string, error) {
+	var isAdmin bool
+	err := s.db.QueryRowContext(ctx, "SELECT is_admin FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, nil, ErrNotFound
+	}
+	if err != nil || isAdmin {
+		return isAdmin, nil, err
+	}
+
+	permissions, err := EffectivePermissions(ctx, s.db, id)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, permissions, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scans a row selected with userColumns
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var emailVerifiedAt, lastLogin, deletedAt sql.NullTime
+	var username sql.NullString
+	var isActive sql.NullBool
+	var metadata []byte
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &emailVerifiedAt, &username, &user.CreatedAt, &user.UpdatedAt, &lastLogin, &isActive, &metadata, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.LastLogin = lastLogin.Time
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	user.Username = username.String
+	user.IsActive = isActive.Bool
+	if user.Metadata, err = UnmarshalMetadata(metadata); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *PostgresUserStore) userGroups(ctx context.Context, id uuid.UUID) ([]models.Group, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT g.id, g.name, g.description, g.created_at, g.updated_at, g.metadata FROM groups g JOIN user_groups ug ON ug.group_id = g.id WHERE ug.user_id = $1 ORDER BY g.name",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		var group models.Group
+		var description sql.NullString
+		var metadata []byte
+		if err := rows.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &metadata); err != nil {
+			return nil, err
+		}
+		group.Description = description.String
+		if group.Metadata, err = UnmarshalMetadata(metadata); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresUserStoreUpdateWritesOnlyGivenFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	id := uuid.New()
+	username := ""
+	isActive := false
+	metadata := map[string]interface{}{"theme": "dark"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, email, email_verified_at, username, created_at, updated_at, last_login, is_active, metadata, deleted_at FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(id, "Some User", "some@example.com", nil, "some", time.Now(), time.Now(), nil, true, nil, nil))
+	mock.ExpectQuery("UPDATE users SET username = \\$1, is_active = \\$2, metadata = \\$3, updated_at = \\$4 WHERE id = \\$5 RETURNING id, name, email, email_verified_at, username").
+		WithArgs(nil, false, `{"theme":"dark"}`, sqlmock.AnyArg(), id).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(id, "Some User", "some@example.com", nil, nil, time.Now(), time.Now(), nil, false, []byte(`{"theme":"dark"}`), nil))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserUpdated, id, sqlmock.AnyArg(), "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	user, err := NewPostgresUserStore(db).Update(context.Background(), id, UserUpdate{Username: &username, IsActive: &isActive, Metadata: &metadata, UpdatedAt: time.Now()}, AuditContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", user.Username)
+	assert.False(t, user.IsActive)
+	assert.Equal(t, metadata, user.Metadata)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresUserStorePurge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	id := uuid.New()
+	cutoff := time.Now().Add(-30 * 24 * time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM users WHERE deleted_at < \\$1 RETURNING id, name, email").
+		WithArgs(cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at", "username", "created_at", "updated_at", "last_login", "is_active", "metadata", "deleted_at"}).
+			AddRow(id, "Some User", "some@example.com", nil, nil, time.Now(), time.Now(), nil, true, nil, cutoff.Add(-time.Hour)))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), nil, models.AuditUserPurged, id, sqlmock.AnyArg(), "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	purged, err := NewPostgresUserStore(db).Purge(context.Background(), cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresUserStoreListFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	active := true
+	groupID := uuid.New()
+	from := time.Now().Add(-24 * time.Hour)
+	opts := ListOptions{
+		Limit:       10,
+		Offset:      20,
+		IsActive:    &active,
+		GroupID:     &groupID,
+		CreatedFrom: from,
+		Query:       "Ada_%",
+		Sort:        []SortField{{Field: "last_login", Desc: true}, {Field: "name"}, {Field: "password"}},
+	}
+
+	where := " WHERE deleted_at IS NULL AND is_active = $1 AND EXISTS (SELECT 1 FROM user_groups ug WHERE ug.user_id = users.id AND ug.group_id = $2) AND created_at >= $3 AND (lower(name) LIKE $4 OR lower(email) LIKE $4 OR lower(username) LIKE $4)"
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users"+where)).
+		WithArgs(true, groupID, from, `ada\_\%%`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	// Fields outside the allow-list never reach the query
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+userColumns+" FROM users"+where+" ORDER BY last_login DESC NULLS LAST, name ASC NULLS LAST, created_at ASC, id ASC LIMIT $5 OFFSET $6")).
+		WithArgs(true, groupID, from, `ada\_\%%`, 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	users, total, err := NewPostgresUserStore(db).List(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Equal(t, 0, total)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresUserStoreListAfterCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	after := UserCursor{CreatedAt: time.Now(), ID: uuid.New()}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+userColumns+" FROM users WHERE deleted_at IS NULL AND (created_at, id) < ($1, $2) ORDER BY created_at DESC NULLS LAST, id DESC LIMIT $3 OFFSET $4")).
+		WithArgs(after.CreatedAt, after.ID, 11, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// Without the count only the page is queried
+	_, _, err = NewPostgresUserStore(db).List(context.Background(), ListOptions{Limit: 11, After: &after, SkipCount: true, Sort: []SortField{{Field: "created_at", Desc: true}}})
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresUserStorePrivileges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	id := uuid.New()
+
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT rp.permission FROM user_groups ug").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow(models.PermissionRolesAdmin))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}))
+
+	users := NewPostgresUserStore(db)
+	isAdmin, permissions, err := users.Privileges(context.Background(), id)
+	assert.NoError(t, err)
+	assert.False(t, isAdmin)
+	assert.Equal(t, []string{models.PermissionRolesAdmin}, permissions)
+
+	_, _, err = users.Privileges(context.Background(), id)
+	assert.Equal(t, ErrNotFound, err, "Deleted or unknown users have no privileges to report")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresUserStoreExistsByEmailIgnoresCase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating the mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)\\)").
+		WithArgs("Ada@Example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	exists, err := NewPostgresUserStore(db).ExistsByEmail(context.Background(), "Ada@Example.com")
+	assert.NoError(t, err)
+	assert.True(t, exists, "An email differing only in case is already registered")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %v", err)
+	}
+}
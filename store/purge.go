@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunPurge hard-deletes users soft-deleted more than retention ago, once at start and then every
+// interval, until stop is closed. A nil stop runs for the life of the process.
+func RunPurge(users UserStore, retention, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := users.Purge(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Error purging deleted users: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d users deleted more than %s ago", purged, retention)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-berry/models"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("user not found")
+
+// ListOptions selects, orders and pages users, zero fields match everything
+type ListOptions struct {
+	Limit          int
+	Offset         int
+	IncludeDeleted bool // soft-deleted users are left out unless set
+
+	Email         string // exact match, ignoring case
+	Username      string // exact match, ignoring case
+	IsActive      *bool
+	GroupID       *uuid.UUID // members of the group
+	CreatedFrom   time.Time  // inclusive
+	CreatedTo     time.Time  // exclusive
+	LastLoginFrom time.Time  // inclusive
+	LastLoginTo   time.Time  // exclusive
+	// Query matches users whose name, email or username starts with it, ignoring case
+	Query string
+	// Sort orders the page, users are finally ordered by created_at then id so pages are stable
+	Sort []SortField
+
+	// After continues a keyset walk from the given user instead of skipping Offset rows, it needs the list
+	// ordered by created_at alone, ascending or descending
+	After *UserCursor
+	// SkipCount leaves out the total, which costs a full scan of the matching users
+	SkipCount bool
+}
+
+// UserCursor is a position in the created_at, id order of users
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// SortField orders users by one of UserSortFields, users without a value come last either way
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// the fields users can be sorted by
+var UserSortFields = []string{"name", "email", "username", "created_at", "updated_at", "last_login"}
+
+// UserUpdate lists the fields to change, nil fields are left as they are
+type UserUpdate struct {
+	Name      *string
+	Email     *string
+	Username  *string // an empty username clears it
+	IsActive  *bool
+	Metadata  *map[string]interface{} // an empty map clears it
+	UpdatedAt time.Time
+}
+
+// UserStore persists users, handlers depend on it instead of on a database handle.
+// Every mutation records an audit event attributed to the given context along with the change.
+// Every call gives up with the context's error once it is cancelled or past its deadline.
+type UserStore interface {
+	// Create inserts a user whose ID and timestamps are already set
+	Create(ctx context.Context, user *models.User, audit AuditContext) error
+	// Get returns a user together with the groups it belongs to, soft-deleted users only when includeDeleted is set
+	Get(ctx context.Context, id uuid.UUID, includeDeleted bool) (*models.User, error)
+	// List returns a page of users and the total number of matching users, zero when SkipCount is set
+	List(ctx context.Context, opts ListOptions) ([]models.User, int, error)
+	// Update writes the given fields of a user that is not deleted and returns the stored user
+	Update(ctx context.Context, id uuid.UUID, update UserUpdate, audit AuditContext) (*models.User, error)
+	// Delete soft-deletes a user, hiding it until it is restored or purged, and returns the deleted user
+	Delete(ctx context.Context, id uuid.UUID, audit AuditContext) (*models.User, error)
+	// Restore brings back a soft-deleted user and returns it
+	Restore(ctx context.Context, id uuid.UUID, audit AuditContext) (*models.User, error)
+	// Purge removes users soft-deleted before the given time for good and returns how many were removed
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	ExistsByUsername(ctx context.Context, username string) (bool, error)
+	// Privileges reports whether a user that is not deleted holds the admin flag, along with the
+	// permissions its groups grant. Admins hold every permission, so theirs are left out.
+	Privileges(ctx context.Context, id uuid.UUID) (isAdmin bool, permissions []string, err error)
+}
@@ -1,9 +1,31 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var passwordHashCost = bcrypt.DefaultCost
+
+// hash of a password nobody has, made with the cost it was last asked for
+var dummyPassword struct {
+	sync.Mutex
+	cost int
+	hash string
+}
+
+// sets the bcrypt cost of passwords hashed from now on, called once at startup
+func SetPasswordHashCost(cost int) {
+	passwordHashCost = cost
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
 	return string(bytes), err
 }
 
@@ -11,3 +33,34 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// returns a hash to compare against when a login names no account, so both paths cost the same. It is
+// made on first use with the configured cost, and made again if the cost changed since.
+func DummyPasswordHash() (string, error) {
+	dummyPassword.Lock()
+	defer dummyPassword.Unlock()
+
+	if dummyPassword.hash == "" || dummyPassword.cost != passwordHashCost {
+		hash, err := HashPassword("go-berry-dummy-password")
+		if err != nil {
+			return "", err
+		}
+		dummyPassword.cost, dummyPassword.hash = passwordHashCost, hash
+	}
+	return dummyPassword.hash, nil
+}
+
+// returns a random URL-safe token carrying 256 bits of entropy
+func GenerateRandomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashes high-entropy tokens for storage, bcrypt is unnecessary for random values
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
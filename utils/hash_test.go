@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDummyPasswordHashFollowsTheCost(t *testing.T) {
+	defer SetPasswordHashCost(passwordHashCost)
+
+	for _, cost := range []int{bcrypt.MinCost, bcrypt.MinCost + 1} {
+		SetPasswordHashCost(cost)
+		hash, err := DummyPasswordHash()
+		if assert.NoError(t, err) {
+			actual, err := bcrypt.Cost([]byte(hash))
+			assert.NoError(t, err)
+			assert.Equal(t, cost, actual, "Unknown logins should cost as much as real ones")
+		}
+	}
+}
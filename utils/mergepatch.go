@@ -0,0 +1,28 @@
+package utils
+
+// MergePatch applies an RFC 7396 merge patch to a decoded JSON document and returns the result.
+// Objects are merged recursively, null removes a member and anything else replaces the target.
+// The target is not modified.
+func MergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	result := make(map[string]interface{}, len(targetObject)+len(patchObject))
+	if ok {
+		for name, value := range targetObject {
+			result[name] = value
+		}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(result, name)
+		} else {
+			result[name] = MergePatch(result[name], value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Stable, machine-readable error codes carried by every problem response
+const (
+	CodeInvalidRequest         = "invalid_request"
+	CodeValidationFailed       = "validation_failed"
+	CodeUnauthorized           = "unauthorized"
+	CodeForbidden              = "forbidden"
+	CodeInvalidCredentials     = "invalid_credentials"
+	CodeAccountDisabled        = "account_disabled"
+	CodeEmailNotVerified       = "email_not_verified"
+	CodeAccountLocked          = "account_locked"
+	CodeTooManyAttempts        = "too_many_attempts"
+	CodeRateLimited            = "rate_limited"
+	CodeInvalidToken           = "invalid_token"
+	CodeInvalidCode            = "invalid_code"
+	CodeUserNotFound           = "user_not_found"
+	CodeGroupNotFound          = "group_not_found"
+	CodeRoleNotFound           = "role_not_found"
+	CodeSessionNotFound        = "session_not_found"
+	CodeMembershipNotFound     = "membership_not_found"
+	CodeRoleNotGranted         = "role_not_granted"
+	CodeTOTPAlreadyEnabled     = "totp_already_enabled"
+	CodeTOTPNotEnrolled        = "totp_not_enrolled"
+	CodeUnsupportedMediaType   = "unsupported_media_type"
+	CodePasswordChangeRequired = "password_change_required"
+	CodeNotReady               = "not_ready"
+	CodeRequestCancelled       = "request_cancelled"
+	CodeRequestTimeout         = "request_timeout"
+	CodeInternal               = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details body extended with a stable error code
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Code   string       `json:"code"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError explains why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects every field that failed validation
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, fieldError := range v {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v *ValidationErrors) add(field, message string) {
+	*v = append(*v, FieldError{Field: field, Message: message})
+}
+
+// returns nil when nothing failed, so callers can return the result directly
+func (v ValidationErrors) orNil() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// writes an application/problem+json response
+func WriteProblem(w http.ResponseWriter, status int, code, detail string) {
+	writeProblem(w, Problem{Status: status, Code: code, Detail: detail})
+}
+
+// writes a 400 listing every rejected field
+func WriteValidationProblem(w http.ResponseWriter, errs ValidationErrors) {
+	writeProblem(w, Problem{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Detail: "The request has invalid fields",
+		Errors: errs,
+	})
+}
+
+// StatusClientClosedRequest is the non-standard status, borrowed from nginx, for clients that hung up
+// before the answer was ready
+const StatusClientClosedRequest = 499
+
+// writes a generic 500, the cause belongs in the server log and not in the response. When the request
+// was cancelled or ran out of time the failure is down to that, so it is answered with a 499 or a 503.
+func WriteInternalError(w http.ResponseWriter, r *http.Request) {
+	switch r.Context().Err() {
+	case context.Canceled:
+		WriteProblem(w, StatusClientClosedRequest, CodeRequestCancelled, "The request was cancelled by the client")
+	case context.DeadlineExceeded:
+		WriteProblem(w, http.StatusServiceUnavailable, CodeRequestTimeout, "The request took too long, try again later")
+	default:
+		WriteProblem(w, http.StatusInternalServerError, CodeInternal, "Internal Server Error")
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	if problem.Status == StatusClientClosedRequest {
+		problem.Title = "Client Closed Request"
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// longest delay a run of failures can impose, lockouts beyond that are the caller's decision
+const maxProgressiveDelay = time.Minute
+
+// returns how long to wait after the given number of consecutive failures: nothing for the first
+// free ones, then base doubling with every further failure
+func ProgressiveDelay(base time.Duration, failures, free int) time.Duration {
+	over := failures - free
+	if over <= 0 || base <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < over && delay < maxProgressiveDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxProgressiveDelay {
+		return maxProgressiveDelay
+	}
+	return delay
+}
+
+// entries beyond this count trigger a sweep of the ones that went quiet
+const throttleSweepSize = 10000
+
+// Throttle counts failures per key in memory and slows each key down progressively
+type Throttle struct {
+	mu      sync.Mutex
+	base    time.Duration
+	free    int
+	window  time.Duration
+	entries map[string]throttleEntry
+	now     func() time.Time
+}
+
+type throttleEntry struct {
+	failures int
+	last     time.Time
+}
+
+// failures are forgotten once a key has been quiet for the window
+func NewThrottle(base time.Duration, free int, window time.Duration) *Throttle {
+	return &Throttle{base: base, free: free, window: window, entries: map[string]throttleEntry{}, now: time.Now}
+}
+
+// returns how long the key still has to wait before its next attempt
+func (t *Throttle) Wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	entry, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	if now.Sub(entry.last) >= t.window {
+		delete(t.entries, key)
+		return 0
+	}
+	if wait := entry.last.Add(ProgressiveDelay(t.base, entry.failures, t.free)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (t *Throttle) Fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	entry := t.entries[key]
+	if now.Sub(entry.last) >= t.window {
+		entry.failures = 0
+	}
+	entry.failures++
+	entry.last = now
+	t.entries[key] = entry
+
+	if len(t.entries) > throttleSweepSize {
+		for k, e := range t.entries {
+			if now.Sub(e.last) >= t.window {
+				delete(t.entries, k)
+			}
+		}
+	}
+}
+
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressiveDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), ProgressiveDelay(time.Second, 2, 2), "Free failures should not be delayed")
+	assert.Equal(t, time.Second, ProgressiveDelay(time.Second, 3, 2))
+	assert.Equal(t, 4*time.Second, ProgressiveDelay(time.Second, 5, 2), "The delay should double with every failure")
+	assert.Equal(t, maxProgressiveDelay, ProgressiveDelay(time.Second, 100, 2), "The delay should be capped")
+}
+
+func TestThrottle(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	throttle := NewThrottle(time.Second, 1, time.Hour)
+	throttle.now = func() time.Time { return start }
+
+	throttle.Fail("192.0.2.1")
+	assert.Equal(t, time.Duration(0), throttle.Wait("192.0.2.1"), "The first failure is free")
+
+	throttle.Fail("192.0.2.1")
+	throttle.Fail("192.0.2.1")
+	assert.Equal(t, 2*time.Second, throttle.Wait("192.0.2.1"))
+	assert.Equal(t, time.Duration(0), throttle.Wait("192.0.2.2"), "Other keys should not be slowed down")
+
+	throttle.now = func() time.Time { return start.Add(2 * time.Second) }
+	assert.Equal(t, time.Duration(0), throttle.Wait("192.0.2.1"), "The delay should run out")
+
+	throttle.Fail("192.0.2.1")
+	throttle.now = func() time.Time { return start.Add(2*time.Second + time.Hour) }
+	assert.Equal(t, time.Duration(0), throttle.Wait("192.0.2.1"), "Failures should be forgotten after the window")
+	throttle.Fail("192.0.2.1")
+	assert.Equal(t, time.Duration(0), throttle.Wait("192.0.2.1"), "Counting should start over after the window")
+
+	throttle.Fail("192.0.2.1")
+	throttle.Reset("192.0.2.1")
+	assert.Equal(t, time.Duration(0), throttle.Wait("192.0.2.1"))
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestTokenRoundTrip(t *testing.T) {
+	tokens := NewTokenManager(testSecret, time.Minute, time.Hour)
+	userID := uuid.New()
+	sessionID := uuid.New()
+
+	token, err := tokens.IssueAccessToken(userID, sessionID)
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+
+	claims, err := tokens.Parse(token, AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, userID.String(), claims.Subject, "Subject should be the user ID")
+	assert.Equal(t, sessionID.String(), claims.SessionID, "Session ID should be embedded")
+	assert.Equal(t, AccessToken, claims.Type, "Token type should be access")
+}
+
+func TestTokenRejectsTamperingAndWrongSecret(t *testing.T) {
+	tokens := NewTokenManager(testSecret, time.Minute, time.Hour)
+
+	token, err := tokens.IssueAccessToken(uuid.New(), uuid.New())
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+
+	forged := token[:len(token)-2] + "xx"
+	_, err = tokens.Parse(forged, AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "Tampered signature should be rejected")
+
+	other := NewTokenManager([]byte("another-secret-another-secret-00"), time.Minute, time.Hour)
+	_, err = other.Parse(token, AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "Token signed with another secret should be rejected")
+
+	_, err = tokens.Parse(token, "refresh")
+	assert.ErrorIs(t, err, ErrInvalidToken, "Token of another type should be rejected")
+}
+
+func TestTokenExpiry(t *testing.T) {
+	tokens := NewTokenManager(testSecret, time.Minute, time.Hour)
+	issuedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tokens.now = func() time.Time { return issuedAt }
+
+	token, err := tokens.IssueAccessToken(uuid.New(), uuid.New())
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+
+	tokens.now = func() time.Time { return issuedAt.Add(59 * time.Second) }
+	_, err = tokens.Parse(token, AccessToken)
+	assert.NoError(t, err, "Token should be valid before it expires")
+
+	tokens.now = func() time.Time { return issuedAt.Add(time.Minute) }
+	_, err = tokens.Parse(token, AccessToken)
+	assert.ErrorIs(t, err, ErrExpiredToken, "Token should be expired after its TTL")
+}
+
+func TestEmailVerificationToken(t *testing.T) {
+	tokens := NewTokenManager(testSecret, time.Minute, time.Hour)
+	userID := uuid.New()
+
+	token, err := tokens.IssueEmailVerificationToken(userID, "ada@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+
+	claims, err := tokens.Parse(token, EmailVerificationToken)
+	assert.NoError(t, err)
+	assert.Equal(t, userID.String(), claims.Subject, "Subject should be the user ID")
+	assert.Equal(t, "ada@example.com", claims.Email, "The verified address should be embedded")
+
+	_, err = tokens.Parse(token, AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "Verification tokens should not work as access tokens")
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// base32 of the RFC 6238 SHA1 test key "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfcSecret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, "Code at %d should match the RFC vector", unix)
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := TOTPCode(rfcSecret, now)
+
+	step, ok := ValidateTOTP(rfcSecret, code, now.Add(30*time.Second))
+	assert.True(t, ok, "Code from the previous step should be accepted")
+	assert.Equal(t, now.Unix()/30, step, "The matched step should be returned")
+
+	_, ok = ValidateTOTP(rfcSecret, code, now.Add(90*time.Second))
+	assert.False(t, ok, "Code from three steps ago should be rejected")
+
+	_, ok = ValidateTOTP(rfcSecret, "12345", now)
+	assert.False(t, ok, "Codes of the wrong length should be rejected")
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("GoBerry", "berry@example.com", rfcSecret)
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/GoBerry:berry@example.com?"), "URI should carry the label")
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=GoBerry")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, "^[a-z2-7]{5}-[a-z2-7]{5}$", code)
+		assert.False(t, seen[code], "Recovery codes should be unique")
+		seen[code] = true
+		assert.Equal(t, code, NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	}
+}
@@ -1,43 +1,111 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"go-berry/models"
+	"go-berry/store"
 	"regexp"
 	"strings"
 )
 
-func ValidateUserInput(user *models.User, db *sql.DB, isUpdate bool) error {
-	if strings.TrimSpace(user.Name) == "" || strings.TrimSpace(user.Email) == "" || strings.TrimSpace(user.Password) == "" {
-			return errors.New("name, email, and password are required")
-	}
+// checks every field and reports all failures at once; any other error comes from the store. Updates
+// pass the stored user, whose email may be kept but not moved to someone else's.
+func ValidateUserInput(ctx context.Context, user *models.User, users store.UserStore, current *models.User) error {
+	var errs ValidationErrors
 
-	if !isValidEmail(user.Email) {
-			return errors.New("invalid email format")
-	}
+	errs.checkName(user.Name)
+	emailValid := errs.checkEmail(user.Email)
+
+	errs.checkPassword("password", user.Password)
 
-	if err := validatePasswordStrength(user.Password); err != nil {
+	if emailValid && (current == nil || !strings.EqualFold(user.Email, current.Email)) {
+		emailExists, err := users.ExistsByEmail(ctx, user.Email)
+		if err != nil {
 			return err
+		}
+		if emailExists {
+			errs.add("email", "email is already registered")
+		}
+	}
+
+	return errs.orNil()
+}
+
+// checks only the fields a merge patch supplied, an email or username may not move to someone else's
+func ValidateUserPatch(ctx context.Context, patched, current *models.User, fields map[string]bool, users store.UserStore) error {
+	var errs ValidationErrors
+
+	if fields["name"] {
+		errs.checkName(patched.Name)
 	}
 
-	if len(user.Name) < 3 || len(user.Name) > 50 {
-			return errors.New("name must be between 3 and 50 characters")
+	if fields["email"] && errs.checkEmail(patched.Email) && !strings.EqualFold(patched.Email, current.Email) {
+		emailExists, err := users.ExistsByEmail(ctx, patched.Email)
+		if err != nil {
+			return err
+		}
+		if emailExists {
+			errs.add("email", "email is already registered")
+		}
 	}
 
-	if !isUpdate {
-			emailExists, err := emailExists(user.Email, db)
+	if fields["username"] && patched.Username != "" {
+		if !usernamePattern.MatchString(patched.Username) {
+			errs.add("username", "username must be 3 to 30 letters, digits, dots, dashes or underscores")
+		} else if !strings.EqualFold(patched.Username, current.Username) {
+			usernameExists, err := users.ExistsByUsername(ctx, patched.Username)
 			if err != nil {
-					return err
+				return err
 			}
-			if emailExists {
-					return errors.New("email is already registered")
+			if usernameExists {
+				errs.add("username", "username is already taken")
 			}
+		}
 	}
 
-	return nil
+	return errs.orNil()
+}
+
+// checks a replacement password, reporting failures under the given field
+func ValidatePasswordInput(field, password string) error {
+	var errs ValidationErrors
+	errs.checkPassword(field, password)
+	return errs.orNil()
 }
 
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,30}$`)
+
+func (v *ValidationErrors) checkName(name string) {
+	if strings.TrimSpace(name) == "" {
+		v.add("name", "name is required")
+	} else if len(name) < 3 || len(name) > 50 {
+		v.add("name", "name must be between 3 and 50 characters")
+	}
+}
+
+func (v *ValidationErrors) checkPassword(field, password string) {
+	if strings.TrimSpace(password) == "" {
+		v.add(field, field+" is required")
+	} else if err := validatePasswordStrength(password); err != nil {
+		v.add(field, err.Error())
+	}
+}
+
+// reports whether the email is well formed
+func (v *ValidationErrors) checkEmail(email string) bool {
+	if strings.TrimSpace(email) == "" {
+		v.add("email", "email is required")
+		return false
+	}
+	if !isValidEmail(email) {
+		v.add("email", "invalid email format")
+		return false
+	}
+	return true
+}
 
 func isValidEmail(email string) bool {
 	const emailRegex = `(?i)^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}$`
@@ -45,10 +113,36 @@ func isValidEmail(email string) bool {
 	return re.MatchString(email)
 }
 
+// PasswordPolicy is what validatePasswordStrength demands of a new password
+type PasswordPolicy struct {
+	MinLength        int
+	MaxLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireNumber    bool
+	RequireSpecial   bool
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:        8,
+	MaxLength:        72,
+	RequireUppercase: true,
+	RequireLowercase: true,
+	RequireNumber:    true,
+	RequireSpecial:   true,
+}
+
+var passwordPolicy = DefaultPasswordPolicy
+
+// replaces the policy new passwords are checked against, called once at startup
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicy = policy
+}
+
 func validatePasswordStrength(password string) error {
 	var uppercase, lowercase, number, special bool
-	if len(password) < 8 || len(password) > 100 {
-		return errors.New("password must be between 8 and 100 characters")
+	if len(password) < passwordPolicy.MinLength || len(password) > passwordPolicy.MaxLength {
+		return fmt.Errorf("password must be between %d and %d characters", passwordPolicy.MinLength, passwordPolicy.MaxLength)
 	}
 	for _, char := range password {
 		switch {
@@ -62,15 +156,114 @@ func validatePasswordStrength(password string) error {
 			special = true
 		}
 	}
-	if !uppercase || !lowercase || !number || !special {
-		return errors.New("password must include at least one uppercase letter, one lowercase letter, one number, and one special character")
+
+	var missing []string
+	if passwordPolicy.RequireUppercase {
+		missing = append(missing, "one uppercase letter")
+	}
+	if passwordPolicy.RequireLowercase {
+		missing = append(missing, "one lowercase letter")
+	}
+	if passwordPolicy.RequireNumber {
+		missing = append(missing, "one number")
+	}
+	if passwordPolicy.RequireSpecial {
+		missing = append(missing, "one special character")
+	}
+	if (passwordPolicy.RequireUppercase && !uppercase) || (passwordPolicy.RequireLowercase && !lowercase) ||
+		(passwordPolicy.RequireNumber && !number) || (passwordPolicy.RequireSpecial && !special) {
+		return errors.New("password must include at least " + joinRequirements(missing))
 	}
 	return nil
 }
 
-func emailExists(email string, db *sql.DB) (bool, error) {
+// lists requirements the way the error messages read, "a, b, and c"
+func joinRequirements(items []string) string {
+	switch len(items) {
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", and " + items[len(items)-1]
+}
+
+func ValidateGroupInput(ctx context.Context, group *models.Group, db *sql.DB) error {
+	var errs ValidationErrors
+
+	group.Name = strings.TrimSpace(group.Name)
+	nameValid := false
+	if group.Name == "" {
+		errs.add("name", "name is required")
+	} else if len(group.Name) < 3 || len(group.Name) > 50 {
+		errs.add("name", "name must be between 3 and 50 characters")
+	} else {
+		nameValid = true
+	}
+
+	if len(group.Description) > 500 {
+		errs.add("description", "description must be at most 500 characters")
+	}
+
+	if nameValid {
+		nameTaken, err := groupNameExists(ctx, group.Name, group.ID.String(), db)
+		if err != nil {
+			return err
+		}
+		if nameTaken {
+			errs.add("name", "group name is already taken")
+		}
+	}
+
+	return errs.orNil()
+}
+
+func ValidateRoleInput(ctx context.Context, role *models.Role, db *sql.DB) error {
+	var errs ValidationErrors
+
+	role.Name = strings.TrimSpace(role.Name)
+	nameValid := len(role.Name) >= 3 && len(role.Name) <= 50
+	if !nameValid {
+		errs.add("name", "name must be between 3 and 50 characters")
+	}
+
+	known := map[string]bool{}
+	for _, permission := range models.Permissions {
+		known[permission] = true
+	}
+
+	// Deduplicate while keeping the order the caller sent
+	permissions := []string{}
+	seen := map[string]bool{}
+	for _, permission := range role.Permissions {
+		if !known[permission] {
+			errs.add("permissions", fmt.Sprintf("unknown permission %q", permission))
+			continue
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	role.Permissions = permissions
+
+	if nameValid {
+		var nameTaken bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM roles WHERE name=$1 AND id<>$2)", role.Name, role.ID.String()).Scan(&nameTaken)
+		if err != nil {
+			return err
+		}
+		if nameTaken {
+			errs.add("name", "role name is already taken")
+		}
+	}
+
+	return errs.orNil()
+}
+
+func groupNameExists(ctx context.Context, name, excludeID string, db *sql.DB) (bool, error) {
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email=$1)", email).Scan(&exists)
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM groups WHERE name=$1 AND id<>$2)", name, excludeID).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-berry/models"
+	"go-berry/store"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUserInputReportsEveryField(t *testing.T) {
+	user := models.User{Name: "Al", Email: "not-an-email", Password: "short"}
+
+	err := ValidateUserInput(context.Background(), &user, store.NewMemoryUserStore(), nil)
+
+	assert.Equal(t, ValidationErrors{
+		{Field: "name", Message: "name must be between 3 and 50 characters"},
+		{Field: "email", Message: "invalid email format"},
+		{Field: "password", Message: "password must be between 8 and 72 characters"},
+	}, err)
+}
+
+func TestValidateUserInputEmailTaken(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	users.Create(context.Background(), &models.User{ID: uuid.New(), Email: "taken@example.com"}, store.AuditContext{})
+
+	user := models.User{Name: "Grace Hopper", Email: "taken@example.com", Password: "StrongP@ssw0rd"}
+	err := ValidateUserInput(context.Background(), &user, users, nil)
+	assert.Equal(t, ValidationErrors{{Field: "email", Message: "email is already registered"}}, err)
+
+	err = ValidateUserInput(context.Background(), &user, users, &models.User{Email: "grace@example.com"})
+	assert.Equal(t, ValidationErrors{{Field: "email", Message: "email is already registered"}}, err, "Updates may not take another user's email")
+
+	assert.NoError(t, ValidateUserInput(context.Background(), &user, users, &models.User{Email: "Taken@example.com"}), "Updates may keep their own email")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	assert.NoError(t, validatePasswordStrength("StrongP@ssw0rd"))
+	assert.Error(t, validatePasswordStrength("StrongP@ssw0rd"+strings.Repeat("x", 60)), "The default policy stops where bcrypt does")
+
+	SetPasswordPolicy(PasswordPolicy{MinLength: 12, MaxLength: 64, RequireLowercase: true, RequireNumber: true})
+	t.Cleanup(func() { SetPasswordPolicy(DefaultPasswordPolicy) })
+
+	assert.EqualError(t, validatePasswordStrength("Sh0rt@pass"), "password must be between 12 and 64 characters")
+	assert.EqualError(t, validatePasswordStrength("lowercase only!"), "password must include at least one lowercase letter and one number")
+	assert.NoError(t, validatePasswordStrength("lowercase and 1 digit"), "Uppercase and special characters should no longer be required")
+}